@@ -0,0 +1,266 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+// Takeout exports arrive as zip or tgz parts. These helpers read them as they are, without extracting them first:
+// the photos are read one at a time through a scratch file, and only the ones that get a location are extracted, to
+// the output directory.
+
+// reports whether the path is an archive that can be read directly
+func isArchive(archivePath string) bool {
+	lower := strings.ToLower(archivePath)
+	return strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".tar.gz")
+}
+
+// calls fn for every regular file in a zip or tgz archive, in the order they're stored.
+// The reader passed to fn is only valid until fn returns.
+func walkArchive(archivePath string, fn func(name string, size int64, modTime time.Time, reader io.Reader) error) error {
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		return walkZip(archivePath, fn)
+	}
+	return walkTarGz(archivePath, fn)
+}
+
+func walkZip(archivePath string, fn func(name string, size int64, modTime time.Time, reader io.Reader) error) error {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		reader, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%v: %w", f.Name, err)
+		}
+		err = fn(f.Name, int64(f.UncompressedSize64), f.Modified, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTarGz(archivePath string, fn func(name string, size int64, modTime time.Time, reader io.Reader) error) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := fn(header.Name, header.Size, header.ModTime, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+// reports whether an archive entry is a location file in the Location History folder of a Takeout.
+// Newer exports name the folder "Location History (Timeline)".
+func isLocationHistoryEntry(name string) bool {
+	if !locationFileExtensions[strings.ToLower(path.Ext(name))] {
+		return false
+	}
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if strings.HasPrefix(part, "Location History") {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the path of an archive entry below dir, keeping its path inside the archive
+func archiveEntryPath(dir, name string) string {
+	// cleaning the name as an absolute path drops any ".." that would escape dir
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// copies an archive entry below dir, keeping its path inside the archive, and returns where it was written
+func extractArchiveEntry(dir, name string, reader io.Reader) (string, error) {
+	target := archiveEntryPath(dir, name)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return "", err
+	}
+	return target, file.Close()
+}
+
+// a photo inside one of the archives of the photos directories. Its metadata is read through a scratch file when the
+// archive is walked, and it's only extracted, to the output directory, when it's written.
+type ArchivePhoto struct {
+	Archive string
+	Name    string
+	// where the photo would be if the archive was extracted to the scratch directory, which is where its Takeout
+	// sidecar is. It's the path the photo is processed with, and mirrored to the output directory like a photos directory
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// the sha256 of the photo and its metadata, with only the tags used to fix it.
+	// Both are empty when it wasn't read because the state has it unchanged since it was processed
+	Hash     string
+	Metadata *exiftool.FileMetadata
+}
+
+// the photos read from archives, by their path
+type ArchivePhotos map[string]*ArchivePhoto
+
+// the path of the photo in the state file
+func (p *ArchivePhoto) statePath() string {
+	return filepath.Join(p.Archive, filepath.FromSlash(p.Name))
+}
+
+// copies the photo to the scratch file, hashing it, reads its metadata and removes the scratch file.
+// The scratch file keeps the extension of the photo, for exiftool to tell its format the same way.
+func (p *ArchivePhoto) read(et *exiftool.Exiftool, scratchDir string, reader io.Reader) error {
+	scratchFile := filepath.Join(scratchDir, "photo"+path.Ext(p.Name))
+	file, err := os.Create(scratchFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(scratchFile)
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), reader); err != nil {
+		file.Close()
+		return fmt.Errorf("%v: %w", p.Name, err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	p.Hash = hex.EncodeToString(hash.Sum(nil))
+
+	fileinfo := et.ExtractMetadata(scratchFile)[0]
+	fileinfo.File = p.Path
+	fileinfo.Fields = fixingFields(fileinfo.Fields)
+	p.Metadata = &fileinfo
+	return nil
+}
+
+// returns the tags used to fix a photo: its GPS, date and time tags, camera and duration. The metadata of the photos
+// in archives is kept until they're processed, without the rest of their tags.
+func fixingFields(fields map[string]interface{}) map[string]interface{} {
+	kept := map[string]interface{}{}
+	for tag, value := range fields {
+		if strings.HasPrefix(tag, "GPS") || strings.Contains(tag, "Date") || strings.Contains(tag, "Time") ||
+			tag == "Make" || tag == "Model" || tag == "Duration" {
+			kept[tag] = value
+		}
+	}
+	return kept
+}
+
+// returns the metadata of the files, read when their archive was walked for the photos in archives, and by exiftool for the others
+func (a ArchivePhotos) extractMetadata(et *exiftool.Exiftool, files ...string) []exiftool.FileMetadata {
+	metadata := make([]exiftool.FileMetadata, len(files))
+	toRead := []string{}
+	indexes := []int{}
+	for i, file := range files {
+		if photo, ok := a[file]; ok && photo.Metadata != nil {
+			metadata[i] = *photo.Metadata
+			continue
+		}
+		toRead = append(toRead, file)
+		indexes = append(indexes, i)
+	}
+	if len(toRead) > 0 {
+		for j, fileinfo := range et.ExtractMetadata(toRead...) {
+			metadata[indexes[j]] = fileinfo
+		}
+	}
+	return metadata
+}
+
+// extracts the photos from their archives to where they're mirrored in the output directory, and returns the copies
+// created. A copy left there by a previous run, which was checked to be the same as the photo, is kept as it is.
+func extractArchivePhotos(photos []*ArchivePhoto) (map[string]bool, error) {
+	targets := map[string]map[string]string{}
+	for _, photo := range photos {
+		target, ok := mirroredPath(photo.Path)
+		if !ok {
+			continue
+		}
+		if targets[photo.Archive] == nil {
+			targets[photo.Archive] = map[string]string{}
+		}
+		targets[photo.Archive][photo.Name] = target
+	}
+
+	created := map[string]bool{}
+	for archivePath, archiveTargets := range targets {
+		err := walkArchive(archivePath, func(name string, size int64, modTime time.Time, reader io.Reader) error {
+			target, ok := archiveTargets[name]
+			if !ok {
+				return nil
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if os.IsExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			created[target] = true
+			if _, err := io.Copy(file, reader); err != nil {
+				file.Close()
+				return fmt.Errorf("%v: %w", target, err)
+			}
+			if err := file.Close(); err != nil {
+				return err
+			}
+			return os.Chtimes(target, modTime, modTime)
+		})
+		if err != nil {
+			return created, fmt.Errorf("%v: %w", archivePath, err)
+		}
+	}
+	return created, nil
+}
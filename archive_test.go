@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the entries of a minimal Takeout, with the Location History next to some photos
+var takeoutEntries = map[string]string{
+	"Takeout/Location History/Records.json": `{"locations": [
+		{"latitudeE7": 395107349, "longitudeE7": -91427899, "timestamp": "2019-04-19T20:08:28.785Z"},
+		{"latitudeE7": 258135945, "longitudeE7": 81338558, "timestamp": "2020-04-19T20:01:28.785Z"}
+	]}`,
+	"Takeout/Location History/Settings.json":            `{"createdTime": "2012-01-01T00:00:00Z"}`,
+	"Takeout/Google Photos/Untitled/photo.jpg":          "not really a jpeg",
+	"Takeout/Google Photos/Untitled/photo.jpg.json":     `{"locations": [{"latitudeE7": 1, "longitudeE7": 1, "timestamp": "2021-01-01T00:00:00Z"}]}`,
+	"Takeout/Google Photos/Untitled/metadata.json":      `{}`,
+	"Takeout/Location History (Timeline)/Timeline.json": `{"rawSignals": [{"position": {"LatLng": "39.5°, -9.1°", "timestamp": "2024-04-19T21:30:00.000+01:00"}}]}`,
+}
+
+func createZip(t *testing.T, entries map[string]string) string {
+	archivePath := filepath.Join(t.TempDir(), "takeout-001.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	for name, content := range entries {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create archive entry: %v", err)
+		}
+		if _, err := io.WriteString(entry, content); err != nil {
+			t.Fatalf("Failed to write archive entry: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	return archivePath
+}
+
+func createTarGz(t *testing.T, entries map[string]string) string {
+	archivePath := filepath.Join(t.TempDir(), "takeout-001.tgz")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	writer := tar.NewWriter(gzipWriter)
+	for name, content := range entries {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to create archive entry: %v", err)
+		}
+		if _, err := io.WriteString(writer, content); err != nil {
+			t.Fatalf("Failed to write archive entry: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	return archivePath
+}
+
+func TestReadLocationsFromArchive(t *testing.T) {
+	archives := map[string]string{
+		"Zip":   createZip(t, takeoutEntries),
+		"TarGz": createTarGz(t, takeoutEntries),
+	}
+
+	for name, archivePath := range archives {
+		t.Run(name, func(t *testing.T) {
+			locations, err := readLocations(archivePath)
+			if err != nil {
+				t.Fatalf("Failed to read locations: %v", err)
+			}
+
+			// the photo sidecar is outside of the Location History folders and must be ignored
+			if locations.Len() != 3 {
+				t.Errorf("Expected 3 locations, got %d", locations.Len())
+			}
+		})
+	}
+}
+
+func TestWalkArchive(t *testing.T) {
+	archivePath := createTarGz(t, takeoutEntries)
+	outputDir := t.TempDir()
+
+	extracted := []string{}
+	err := walkArchive(archivePath, func(name string, size int64, modTime time.Time, reader io.Reader) error {
+		if !strings.HasSuffix(name, ".jpg") {
+			return nil
+		}
+		path, err := extractArchiveEntry(outputDir, name, reader)
+		extracted = append(extracted, path)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk archive: %v", err)
+	}
+
+	expectedPath := filepath.Join(outputDir, "Takeout", "Google Photos", "Untitled", "photo.jpg")
+	if len(extracted) != 1 || extracted[0] != expectedPath {
+		t.Fatalf("Expected only %v to be extracted, got %v", expectedPath, extracted)
+	}
+	data, err := os.ReadFile(expectedPath)
+	if err != nil {
+		t.Fatalf("Failed to read extracted file: %v", err)
+	}
+	if string(data) != "not really a jpeg" {
+		t.Errorf("Unexpected extracted content %q", data)
+	}
+}
+
+func TestReadCorruptArchivePhoto(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "takeout-001.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	writer := zip.NewWriter(file)
+	for _, name := range []string{"Takeout/first.jpg", "Takeout/second.jpg"} {
+		entry, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatalf("Failed to create archive entry: %v", err)
+		}
+		if _, err := io.WriteString(entry, "content of "+name); err != nil {
+			t.Fatalf("Failed to write archive entry: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	file.Close()
+
+	// the content of the first photo no longer matches its CRC
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	data = []byte(strings.Replace(string(data), "content of Takeout/first.jpg", "CONTENT OF Takeout/first.jpg", 1))
+	if err := os.WriteFile(archivePath, data, 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	// the photo that can't be read fails on its own, the walk goes on with the next one
+	failed := []string{}
+	walked := []string{}
+	err = walkArchive(archivePath, func(name string, size int64, modTime time.Time, reader io.Reader) error {
+		walked = append(walked, name)
+		photo := &ArchivePhoto{Archive: archivePath, Name: name}
+		if name == "Takeout/first.jpg" {
+			if err := photo.read(nil, t.TempDir(), reader); err != nil {
+				failed = append(failed, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk archive: %v", err)
+	}
+	if len(failed) != 1 || len(walked) != 2 {
+		t.Errorf("Expected the first photo to fail to be read and both to be walked, got %v failed of %v", failed, walked)
+	}
+}
+
+func TestExtractArchivePhotos(t *testing.T) {
+	entries := map[string]string{
+		"Takeout/Google Photos/Untitled/photo.jpg": "not really a jpeg",
+		"Takeout/Google Photos/Untitled/other.jpg": "another photo",
+		"Takeout/Google Photos/Untitled/kept.jpg":  "from the archive",
+	}
+	archivePath := createZip(t, entries)
+	_, outDir := setupTestOutputDir(t, "none")
+	originalArchiveDirectory := archiveDirectory
+	defer func() { archiveDirectory = originalArchiveDirectory }()
+	archiveDirectory = t.TempDir()
+
+	// a copy left by a previous run is never overwritten
+	kept := filepath.Join(outDir, "Takeout", "Google Photos", "Untitled", "kept.jpg")
+	if err := os.MkdirAll(filepath.Dir(kept), 0755); err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	if err := os.WriteFile(kept, []byte("fixed by a previous run"), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+
+	photos := []*ArchivePhoto{}
+	for _, name := range []string{"Takeout/Google Photos/Untitled/photo.jpg", "Takeout/Google Photos/Untitled/kept.jpg"} {
+		photos = append(photos, &ArchivePhoto{Archive: archivePath, Name: name, Path: archiveEntryPath(archiveDirectory, name)})
+	}
+	created, err := extractArchivePhotos(photos)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	photo := filepath.Join(outDir, "Takeout", "Google Photos", "Untitled", "photo.jpg")
+	if len(created) != 1 || !created[photo] {
+		t.Errorf("Expected only %v to be created, got %v", photo, created)
+	}
+	if content, err := os.ReadFile(photo); err != nil || string(content) != "not really a jpeg" {
+		t.Errorf("Expected the photo to be extracted, got %q: %v", content, err)
+	}
+	if content, _ := os.ReadFile(kept); string(content) != "fixed by a previous run" {
+		t.Errorf("Expected the copy of the previous run to be left as is, got %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "Takeout", "Google Photos", "Untitled", "other.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected the photo that isn't written not to be extracted")
+	}
+}
+
+func TestExtractArchiveEntryStaysInsideDirectory(t *testing.T) {
+	outputDir := t.TempDir()
+
+	path, err := extractArchiveEntry(outputDir, "../../escaped.jpg", strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("Failed to extract entry: %v", err)
+	}
+	if path != filepath.Join(outputDir, "escaped.jpg") {
+		t.Errorf("Expected the entry to be extracted inside %v, got %v", outputDir, path)
+	}
+}
+
+func TestIsLocationHistoryEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"Takeout/Location History/Records.json", true},
+		{"Takeout/Location History/Semantic Location History/2019/2019_APRIL.json", true},
+		{"Takeout/Location History (Timeline)/Records.json", true},
+		{"Takeout/Location History/Location History.html", false},
+		{"Takeout/Google Photos/Untitled/photo.jpg.json", false},
+		{"Records.json", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := isLocationHistoryEntry(tt.name); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
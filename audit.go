@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+	"github.com/google/btree"
+	"github.com/sirupsen/logrus"
+)
+
+// Photos that already have GPS data are skipped, but bad phone fixes or earlier tools may have put them in the wrong
+// place. The audit command compares the GPS data of these photos with the location history at the time they were
+// taken and lists the outliers, which --fix-outliers then writes the location history position to.
+
+// a photo with GPS data, compared with the location history at the time it was taken
+type auditSample struct {
+	file        string
+	captureTime time.Time
+	// whether the capture time has an offset, without one it's resolved in the time zone of the location like when fixing
+	hasOffset bool
+	position  Location
+}
+
+type AuditResult struct {
+	File     string
+	Position Location
+	// the location history position at the time the photo was taken
+	Expected Location
+	// distance in metres between the two
+	Distance float64
+}
+
+// compares the position of each sample with the location history position at its capture time, found the same way
+// as when fixing the photo. Returns the results sorted from the furthest, and the number of samples without location
+// history to compare with.
+func auditSamples(locations *btree.BTreeG[Location], samples []auditSample, boundaries *TimezoneBoundaries) ([]AuditResult, int) {
+	results := []AuditResult{}
+	withoutHistory := 0
+	for _, sample := range samples {
+		var expected *Location
+		if sample.hasOffset {
+			expected = findLocationFromDate(locations, sample.captureTime)
+		} else {
+			expected, _ = findLocationFromLocalTime(locations, sample.captureTime, boundaries)
+		}
+		if expected == nil {
+			withoutHistory++
+			continue
+		}
+		results = append(results, AuditResult{
+			File:     sample.file,
+			Position: sample.position,
+			Expected: *expected,
+			Distance: distance(sample.position, *expected),
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Distance > results[j].Distance })
+	return results, withoutHistory
+}
+
+// the audit command, reports the photos whose GPS data is further than --outlier-distance from the location history.
+// Returns the outliers, for --fix-outliers to write the location history position to them.
+func runAudit(et *exiftool.Exiftool, locations *btree.BTreeG[Location], filesToProcess []string, archivePhotos ArchivePhotos, timezone *time.Location, timezoneBoundaries *TimezoneBoundaries, offsetsByCamera map[string]time.Duration) []string {
+	logrus.Infof("Reading the photos that already have GPS metadata")
+
+	samples := []auditSample{}
+	for batchStart := 0; batchStart < len(filesToProcess); batchStart += batchSize {
+		for _, fileinfo := range archivePhotos.extractMetadata(et, filesToProcess[batchStart:min(batchStart+batchSize, len(filesToProcess))]...) {
+			if fileinfo.Err != nil {
+				logrus.Warnf("Skipping file %v because of an error when extracting metadata: %v", fileinfo.File, fileinfo.Err)
+				continue
+			}
+
+			latitude, longitude, ok := readGPSCoordinates(fileinfo)
+			if !ok {
+				continue
+			}
+			captureTime, hasOffset, _, err := readCaptureTime(fileinfo, fileTimeSources(fileinfo.File, archivePhotos), timezone)
+			if err != nil {
+				logrus.Debugf("Skipping file %v because we couldn't determine the time the photo was taken: %v", fileinfo.File, err)
+				continue
+			}
+			if cameraOffset, ok := offsetsByCamera[cameraName(fileinfo)]; ok {
+				captureTime = captureTime.Add(cameraOffset)
+			}
+
+			samples = append(samples, auditSample{
+				file:        fileinfo.File,
+				captureTime: captureTime,
+				hasOffset:   hasOffset,
+				position:    locationFromDegrees(latitude, longitude, captureTime),
+			})
+		}
+	}
+
+	results, withoutHistory := auditSamples(locations, samples, timezoneBoundaries)
+	outliers := []string{}
+	distances := []float64{}
+	for _, result := range results {
+		distances = append(distances, result.Distance)
+		if result.Distance <= *outlierDistance {
+			continue
+		}
+		outliers = append(outliers, result.File)
+		logrus.Warnf("%v is %.0fm from the location history: its GPS data is %v, %v but the location history has %v, %v at %v",
+			result.File, result.Distance, result.Position.latitude(), result.Position.longitude(),
+			result.Expected.latitude(), result.Expected.longitude(), result.Expected.Timestamp)
+	}
+
+	logrus.Infof("Summary:")
+	logrus.Infof("\tFiles with GPS metadata and a capture time: %v", len(samples))
+	logrus.Infof("\tFiles without location history to compare with: %v", withoutHistory)
+	if len(distances) > 0 {
+		logrus.Infof("\tMedian distance to the location history: %.0fm", medianOf(distances))
+	}
+	logrus.Infof("\tFiles further than %vm from the location history: %v", *outlierDistance, len(outliers))
+	return outliers
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/btree"
+)
+
+func TestAuditSamples(t *testing.T) {
+	// a walk through Lisbon, one point every 10 minutes, 100m apart
+	start := time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	locations := btree.NewG[Location](2, locationLessFunc)
+	for i := 0; i < 6; i++ {
+		locations.ReplaceOrInsert(Location{LatitudeE7: 387000000 + i*9000, LongitudeE7: -91000000, Timestamp: start.Add(time.Duration(i) * 10 * time.Minute)})
+	}
+
+	// Save original tolerance
+	originalTolerance := tolerance
+	defer func() { tolerance = originalTolerance }()
+	testTolerance := 5 * time.Minute
+	tolerance = &testTolerance
+
+	samples := []auditSample{
+		// where the location history has it
+		{file: "right.jpg", captureTime: start.Add(20 * time.Minute), position: Location{LatitudeE7: 387018000, LongitudeE7: -91000000}},
+		// a bad fix in Porto
+		{file: "porto.jpg", captureTime: start.Add(30 * time.Minute), position: Location{LatitudeE7: 411579000, LongitudeE7: -86291000}},
+		// a few hundred metres off
+		{file: "nearby.jpg", captureTime: start.Add(40 * time.Minute), position: Location{LatitudeE7: 387076000, LongitudeE7: -91000000}},
+		// hours after the location history ends
+		{file: "later.jpg", captureTime: start.Add(5 * time.Hour), position: Location{LatitudeE7: 411579000, LongitudeE7: -86291000}},
+	}
+
+	results, withoutHistory := auditSamples(locations, samples, nil)
+	if withoutHistory != 1 {
+		t.Errorf("Expected 1 sample without location history, got %v", withoutHistory)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %v", len(results))
+	}
+
+	expectedFiles := []string{"porto.jpg", "nearby.jpg", "right.jpg"}
+	for i, file := range expectedFiles {
+		if results[i].File != file {
+			t.Errorf("Expected result %v to be %v, got %v", i, file, results[i].File)
+		}
+	}
+	if results[0].Distance < 270000 || results[0].Distance > 280000 {
+		t.Errorf("Expected Porto to be about 274km from the location history, got %vm", results[0].Distance)
+	}
+	if math.Abs(results[1].Distance-445) > 5 {
+		t.Errorf("Expected the nearby photo to be about 445m from the location history, got %vm", results[1].Distance)
+	}
+	if results[2].Distance != 0 || !results[2].Expected.Timestamp.Equal(start.Add(20*time.Minute)) {
+		t.Errorf("Expected the right photo to match the location history at %v, got %vm at %v", start.Add(20*time.Minute), results[2].Distance, results[2].Expected.Timestamp)
+	}
+}
+
+func TestAuditSamplesLocalTime(t *testing.T) {
+	// a walk through Madrid for 6 hours, one point every 10 minutes, 100m apart
+	start := time.Date(2019, 7, 1, 8, 0, 0, 0, time.UTC)
+	locations := btree.NewG[Location](2, locationLessFunc)
+	for i := 0; i < 36; i++ {
+		locations.ReplaceOrInsert(Location{LatitudeE7: 404168000 + i*9000, LongitudeE7: -37038000, Timestamp: start.Add(time.Duration(i) * 10 * time.Minute)})
+	}
+
+	originalTolerance := tolerance
+	defer func() { tolerance = originalTolerance }()
+	testTolerance := 5 * time.Minute
+	tolerance = &testTolerance
+
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+	taken := start.Add(3 * time.Hour)
+	position := Location{LatitudeE7: 404168000 + 18*9000, LongitudeE7: -37038000}
+	samples := []auditSample{
+		// taken on Madrid time (UTC+2 in July) without an offset tag, so it's read in UTC
+		{file: "local.jpg", captureTime: wallClockIn(taken.In(madrid), time.UTC), position: position},
+		{file: "offset.jpg", captureTime: taken, hasOffset: true, position: position},
+	}
+
+	results, withoutHistory := auditSamples(locations, samples, readTestTimezoneBoundaries(t))
+	if withoutHistory != 0 || len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v and %v without location history", len(results), withoutHistory)
+	}
+	for _, result := range results {
+		if result.Distance != 0 || !result.Expected.Timestamp.Equal(taken) {
+			t.Errorf("Expected %v to match the location history at %v, got %vm at %v", result.File, taken, result.Distance, result.Expected.Timestamp)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+	"github.com/sirupsen/logrus"
+)
+
+// Scans, screenshots and images received in chat apps often have no DateTimeOriginal. The time they were taken is then
+// read from the first of the --time-sources that has it.
+
+// reads the capture time of a photo from one source. hasOffset is false when the time is a wall clock time in the given zone.
+type captureTimeSource func(fileinfo exiftool.FileMetadata, zone *time.Location) (captureTime time.Time, hasOffset bool, err error)
+
+var errCaptureTimeNotSet = errors.New("not set")
+
+var captureTimeSources = map[string]captureTimeSource{
+	"DateTimeOriginal":       dateTimeOriginalCaptureTime,
+	"SubSecDateTimeOriginal": exifCaptureTime([]string{"SubSecDateTimeOriginal"}, "OffsetTimeOriginal", "OffsetTime"),
+	"CreateDate":             exifCaptureTime([]string{"CreateDate"}, "OffsetTimeDigitized", "OffsetTime"),
+	"ModifyDate":             exifCaptureTime([]string{"ModifyDate"}, "OffsetTime"),
+	"sidecar":                sidecarCaptureTime,
+	"filename":               filenameCaptureTime,
+	"mtime":                  mtimeCaptureTime,
+}
+
+// the sources in the order they're tried by default
+var defaultTimeSources = []string{"DateTimeOriginal", "SubSecDateTimeOriginal", "CreateDate", "ModifyDate", "sidecar", "filename", "mtime"}
+
+func validateTimeSources(sources []string) error {
+	for _, source := range sources {
+		if _, ok := captureTimeSources[source]; !ok {
+			return fmt.Errorf("unknown time source %v, must be one of %v", source, strings.Join(defaultTimeSources, ", "))
+		}
+	}
+	return nil
+}
+
+// reads the capture time from the first of the sources that has a valid one and returns the name of that source
+func readCaptureTime(fileinfo exiftool.FileMetadata, sources []string, zone *time.Location) (captureTime time.Time, hasOffset bool, source string, err error) {
+	for _, source := range sources {
+		captureTime, hasOffset, err := captureTimeSources[source](fileinfo, zone)
+		if err == nil {
+			return captureTime, hasOffset, source, nil
+		}
+		if err != errCaptureTimeNotSet {
+			logrus.Debugf("Ignoring the %v of file %v: %v", source, fileinfo.File, err)
+		}
+	}
+	return time.Time{}, false, "", fmt.Errorf("none of %v is set", strings.Join(sources, ", "))
+}
+
+// reads the first of the date time tags that is set, with the offset from the first of the offset tags that is set
+func exifCaptureTime(tags []string, offsetTags ...string) captureTimeSource {
+	return func(fileinfo exiftool.FileMetadata, zone *time.Location) (time.Time, bool, error) {
+		value := ""
+		for _, tag := range tags {
+			if value, _ = fileinfo.GetString(tag); value != "" {
+				break
+			}
+		}
+		if value == "" {
+			return time.Time{}, false, errCaptureTimeNotSet
+		}
+
+		// the composite SubSec tags carry the offset themselves
+		if captureTime, err := time.Parse(exifDateTimeLayout+"Z07:00", value); err == nil {
+			return captureTime, true, nil
+		}
+
+		offset := ""
+		for _, offsetTag := range offsetTags {
+			if offset, _ = fileinfo.GetString(offsetTag); offset != "" {
+				break
+			}
+		}
+		return parseCaptureTime(value, offset, zone)
+	}
+}
+
+// reads the date time tags the format of the file keeps the capture time in
+func dateTimeOriginalCaptureTime(fileinfo exiftool.FileMetadata, zone *time.Location) (time.Time, bool, error) {
+	handler := mediaHandlerFor(fileinfo.File)
+	if handler == nil {
+		return exifCaptureTime([]string{"DateTimeOriginal"}, "OffsetTimeOriginal", "OffsetTime")(fileinfo, zone)
+	}
+	if handler.DateTimeUTC {
+		captureTime, _, err := exifCaptureTime(handler.DateTimeTags)(fileinfo, time.UTC)
+		return captureTime, err == nil, err
+	}
+	return exifCaptureTime(handler.DateTimeTags, "OffsetTimeOriginal", "OffsetTime")(fileinfo, zone)
+}
+
+func sidecarCaptureTime(fileinfo exiftool.FileMetadata, zone *time.Location) (time.Time, bool, error) {
+	sidecarPath := findTakeoutSidecar(fileinfo.File)
+	if sidecarPath == "" {
+		return time.Time{}, false, errCaptureTimeNotSet
+	}
+	sidecar, err := readTakeoutSidecar(sidecarPath)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	takenTime, err := sidecar.takenTime()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if takenTime.IsZero() {
+		return time.Time{}, false, errCaptureTimeNotSet
+	}
+	return takenTime, true, nil
+}
+
+// matches the date and time in names like IMG_20190419_200128.jpg, PXL_20190419_200128123.jpg or Screenshot_20190419-200128.png
+var filenameTimePattern = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)[0-9]{6})[_-]([0-9]{6})([0-9]{3})?(?:[^0-9]|$)`)
+
+func filenameCaptureTime(fileinfo exiftool.FileMetadata, zone *time.Location) (time.Time, bool, error) {
+	name := filepath.Base(fileinfo.File)
+	match := filenameTimePattern.FindStringSubmatch(name)
+	if match == nil {
+		return time.Time{}, false, errCaptureTimeNotSet
+	}
+
+	dateTime := match[1] + match[2]
+	if match[3] != "" {
+		dateTime += "." + match[3]
+	}
+	// Pixel phones name their photos after the UTC time, other cameras after the local time
+	if strings.HasPrefix(name, "PXL_") {
+		captureTime, err := time.Parse("20060102150405", dateTime)
+		return captureTime, err == nil, err
+	}
+	captureTime, err := time.ParseInLocation("20060102150405", dateTime, zone)
+	return captureTime, false, err
+}
+
+func mtimeCaptureTime(fileinfo exiftool.FileMetadata, zone *time.Location) (time.Time, bool, error) {
+	info, err := os.Stat(fileinfo.File)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return info.ModTime().UTC(), true, nil
+}
+
+// returns the --time-sources that apply to the file. The photos in archives aren't on disk and the modification time
+// of their entries is when the archive was made, so it's left out for them.
+func fileTimeSources(path string, archivePhotos ArchivePhotos) []string {
+	if _, ok := archivePhotos[path]; !ok {
+		return *timeSources
+	}
+	sources := []string{}
+	for _, source := range *timeSources {
+		if source != "mtime" {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
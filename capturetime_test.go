@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+func TestReadCaptureTime(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sidecarPhoto := filepath.Join(tmpDir, "received.jpg")
+	if err := os.WriteFile(sidecarPhoto+".json", []byte(`{"title": "received.jpg", "photoTakenTime": {"timestamp": "1555704508", "formatted": "19 Apr 2019, 20:08:28 UTC"}}`), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+
+	mtimePhoto := filepath.Join(tmpDir, "scan.jpg")
+	if err := os.WriteFile(mtimePhoto, nil, 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	mtime := time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC)
+	if err := os.Chtimes(mtimePhoto, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set the modification time: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		file        string
+		fields      map[string]interface{}
+		sources     []string
+		expected    time.Time
+		hasOffset   bool
+		source      string
+		expectError bool
+	}{
+		{
+			name:      "DateTimeOriginal",
+			file:      "photo.jpg",
+			fields:    map[string]interface{}{"DateTimeOriginal": "2019:04:19 21:08:28", "OffsetTime": "+01:00", "CreateDate": "2019:04:19 22:00:00"},
+			sources:   defaultTimeSources,
+			expected:  time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+			hasOffset: true,
+			source:    "DateTimeOriginal",
+		},
+		{
+			name:      "SubSecDateTimeOriginal",
+			file:      "photo.jpg",
+			fields:    map[string]interface{}{"SubSecDateTimeOriginal": "2019:04:19 21:08:28.500+01:00"},
+			sources:   defaultTimeSources,
+			expected:  time.Date(2019, 4, 19, 20, 8, 28, 500000000, time.UTC),
+			hasOffset: true,
+			source:    "SubSecDateTimeOriginal",
+		},
+		{
+			name:     "InvalidDateTimeOriginalFallsBack",
+			file:     "photo.jpg",
+			fields:   map[string]interface{}{"DateTimeOriginal": "0000:00:00 00:00:00", "CreateDate": "2019:04:19 20:08:28"},
+			sources:  defaultTimeSources,
+			expected: time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+			source:   "CreateDate",
+		},
+		{
+			name:      "ModifyDate",
+			file:      "photo.jpg",
+			fields:    map[string]interface{}{"ModifyDate": "2019:04:19 21:08:28", "OffsetTime": "+01:00"},
+			sources:   defaultTimeSources,
+			expected:  time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+			hasOffset: true,
+			source:    "ModifyDate",
+		},
+		{
+			name:      "Sidecar",
+			file:      sidecarPhoto,
+			fields:    map[string]interface{}{},
+			sources:   defaultTimeSources,
+			expected:  time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+			hasOffset: true,
+			source:    "sidecar",
+		},
+		{
+			name:     "Filename",
+			file:     "IMG_20190419_200828.jpg",
+			fields:   map[string]interface{}{},
+			sources:  defaultTimeSources,
+			expected: time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+			source:   "filename",
+		},
+		{
+			name:      "PixelFilenameIsUTC",
+			file:      "PXL_20190419_200828500.jpg",
+			fields:    map[string]interface{}{},
+			sources:   defaultTimeSources,
+			expected:  time.Date(2019, 4, 19, 20, 8, 28, 500000000, time.UTC),
+			hasOffset: true,
+			source:    "filename",
+		},
+		{
+			name:      "Mtime",
+			file:      mtimePhoto,
+			fields:    map[string]interface{}{},
+			sources:   defaultTimeSources,
+			expected:  mtime,
+			hasOffset: true,
+			source:    "mtime",
+		},
+		{
+			name:        "SourcesInOrder",
+			file:        "IMG_20190419_200828.jpg",
+			fields:      map[string]interface{}{"ModifyDate": "2019:04:19 21:08:28"},
+			sources:     []string{"DateTimeOriginal"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileinfo := exiftool.FileMetadata{File: tt.file, Fields: tt.fields}
+			result, hasOffset, source, err := readCaptureTime(fileinfo, tt.sources, time.UTC)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got %v from %v", result, source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !result.Equal(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+			if hasOffset != tt.hasOffset {
+				t.Errorf("Expected hasOffset %v, got %v", tt.hasOffset, hasOffset)
+			}
+			if source != tt.source {
+				t.Errorf("Expected source %v, got %v", tt.source, source)
+			}
+		})
+	}
+}
+
+func TestFileTimeSources(t *testing.T) {
+	archivePhotos := ArchivePhotos{"fixed/photo.jpg": &ArchivePhoto{}}
+
+	if sources := fileTimeSources("photos/photo.jpg", archivePhotos); len(sources) != len(defaultTimeSources) {
+		t.Errorf("Expected all the time sources, got %v", sources)
+	}
+	for _, source := range fileTimeSources("fixed/photo.jpg", archivePhotos) {
+		if source == "mtime" {
+			t.Errorf("Expected no mtime source for a photo in an archive")
+		}
+	}
+}
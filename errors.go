@@ -0,0 +1,50 @@
+package main
+
+import "github.com/sirupsen/logrus"
+
+// A file that can't be read or written is skipped and the run goes on with the others. The failures are listed at
+// the end of the run, which then exits with a non-zero code, and the run is aborted when there are more than --max-errors.
+
+type FileError struct {
+	File string
+	// what was being done with the file: read or write
+	Operation string
+	Reason    string
+}
+
+type FileErrors struct {
+	// the number of failures after which the run is aborted, 0 for no limit
+	max    int
+	errors []FileError
+}
+
+func (e *FileErrors) add(file, operation string, err error) {
+	e.errors = append(e.errors, FileError{File: file, Operation: operation, Reason: err.Error()})
+}
+
+// reports whether there are more failures than allowed
+func (e *FileErrors) tooMany() bool {
+	return e.max > 0 && len(e.errors) > e.max
+}
+
+// returns the number of failures of the operation
+func (e *FileErrors) count(operation string) int {
+	count := 0
+	for _, fileError := range e.errors {
+		if fileError.Operation == operation {
+			count++
+		}
+	}
+	return count
+}
+
+// logs the files that failed with the reason of each failure
+func (e *FileErrors) log() {
+	if len(e.errors) == 0 {
+		return
+	}
+	logrus.Errorf("%v files failed:", len(e.errors))
+	for _, fileError := range e.errors {
+		logrus.Errorf("\t%v (%v): %v", fileError.File, fileError.Operation, fileError.Reason)
+	}
+}
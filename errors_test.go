@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		failures int
+		expected bool
+	}{
+		{"no limit", 0, 100, false},
+		{"under the limit", 3, 2, false},
+		{"at the limit", 3, 3, false},
+		{"over the limit", 3, 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileErrors := &FileErrors{max: tt.max}
+			for i := 0; i < tt.failures; i++ {
+				fileErrors.add("photo.jpg", "read", errors.New("corrupt"))
+			}
+			if result := fileErrors.tooMany(); result != tt.expected {
+				t.Errorf("Expected tooMany() to be %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	fileErrors := &FileErrors{}
+	fileErrors.add("corrupt.jpg", "read", errors.New("file format error"))
+	fileErrors.add("readonly.jpg", "write", errors.New("permission denied"))
+	fileErrors.add("truncated.jpg", "read", errors.New("unexpected end of file"))
+	if fileErrors.count("read") != 2 || fileErrors.count("write") != 1 {
+		t.Errorf("Expected 2 read and 1 write failures, got %v and %v", fileErrors.count("read"), fileErrors.count("write"))
+	}
+	if fileErrors.errors[1].File != "readonly.jpg" || fileErrors.errors[1].Reason != "permission denied" {
+		t.Errorf("Expected the failure of readonly.jpg with its reason, got %+v", fileErrors.errors[1])
+	}
+}
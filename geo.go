@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// mean radius of the earth in metres, as used by the haversine formula
+const earthRadius = 6371008.8
+
+func (l Location) latitude() float64 {
+	return float64(l.LatitudeE7) / 1e7
+}
+
+func (l Location) longitude() float64 {
+	return float64(l.LongitudeE7) / 1e7
+}
+
+// returns the great-circle distance in metres between two locations
+func distance(a, b Location) float64 {
+	lat1, lat2 := toRadians(a.latitude()), toRadians(b.latitude())
+	deltaLat := lat2 - lat1
+	deltaLon := toRadians(b.longitude() - a.longitude())
+
+	h := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// returns the location at time t between a and b, assuming constant speed from one to the other.
+// With linear the coordinates are interpolated as plain numbers, otherwise along the great circle between the points.
+func interpolateLocation(a, b Location, t time.Time, linear bool) Location {
+	fraction := 0.0
+	if gap := b.Timestamp.Sub(a.Timestamp); gap > 0 {
+		fraction = float64(t.Sub(a.Timestamp)) / float64(gap)
+	}
+
+	var latitude, longitude float64
+	if linear {
+		latitude = a.latitude() + (b.latitude()-a.latitude())*fraction
+		longitude = a.longitude() + (b.longitude()-a.longitude())*fraction
+	} else {
+		latitude, longitude = intermediatePoint(a, b, fraction)
+	}
+
+	interpolated := locationFromDegrees(latitude, longitude, t)
+	interpolated.Accuracy = max(a.Accuracy, b.Accuracy)
+	interpolated.Priority = max(a.Priority, b.Priority)
+	if a.Altitude != nil && b.Altitude != nil {
+		altitude := *a.Altitude + (*b.Altitude-*a.Altitude)*fraction
+		interpolated.Altitude = &altitude
+	}
+	return interpolated
+}
+
+// returns the point at the given fraction of the great circle path from a to b
+func intermediatePoint(a, b Location, fraction float64) (float64, float64) {
+	lat1, lon1 := toRadians(a.latitude()), toRadians(a.longitude())
+	lat2, lon2 := toRadians(b.latitude()), toRadians(b.longitude())
+
+	angle := distance(a, b) / earthRadius
+	if angle < 1e-12 {
+		return a.latitude(), a.longitude()
+	}
+
+	weightA := math.Sin((1-fraction)*angle) / math.Sin(angle)
+	weightB := math.Sin(fraction*angle) / math.Sin(angle)
+
+	x := weightA*math.Cos(lat1)*math.Cos(lon1) + weightB*math.Cos(lat2)*math.Cos(lon2)
+	y := weightA*math.Cos(lat1)*math.Sin(lon1) + weightB*math.Cos(lat2)*math.Sin(lon2)
+	z := weightA*math.Sin(lat1) + weightB*math.Sin(lat2)
+
+	return toDegrees(math.Atan2(z, math.Sqrt(x*x+y*y))), toDegrees(math.Atan2(y, x))
+}
+
+func toDegrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
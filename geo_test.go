@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        Location
+		b        Location
+		expected float64
+	}{
+		{
+			name:     "SamePoint",
+			a:        Location{LatitudeE7: 395107349, LongitudeE7: -91427899},
+			b:        Location{LatitudeE7: 395107349, LongitudeE7: -91427899},
+			expected: 0,
+		},
+		{
+			name:     "OneDegreeOfLatitude",
+			a:        Location{LatitudeE7: 0, LongitudeE7: 0},
+			b:        Location{LatitudeE7: 10000000, LongitudeE7: 0},
+			expected: 111195,
+		},
+		{
+			name:     "LisbonToMadrid",
+			a:        Location{LatitudeE7: 387223000, LongitudeE7: -91393000},
+			b:        Location{LatitudeE7: 404168000, LongitudeE7: -37038000},
+			expected: 502600,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := distance(tt.a, tt.b)
+			// within 0.1% of the expected value
+			if math.Abs(result-tt.expected) > tt.expected/1000+1 {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestInterpolateLocation(t *testing.T) {
+	start := time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC)
+	quarter := start.Add(15 * time.Minute)
+	end := start.Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		a           Location
+		b           Location
+		t           time.Time
+		linear      bool
+		expectedLat float64
+		expectedLon float64
+	}{
+		{
+			name:        "LinearQuarter",
+			a:           Location{LatitudeE7: 0, LongitudeE7: 0, Timestamp: start},
+			b:           Location{LatitudeE7: 40000000, LongitudeE7: -80000000, Timestamp: end},
+			t:           quarter,
+			linear:      true,
+			expectedLat: 1,
+			expectedLon: -2,
+		},
+		{
+			name:        "GreatCircleAlongEquator",
+			a:           Location{LatitudeE7: 0, LongitudeE7: 0, Timestamp: start},
+			b:           Location{LatitudeE7: 0, LongitudeE7: 900000000, Timestamp: end},
+			t:           start.Add(30 * time.Minute),
+			expectedLat: 0,
+			expectedLon: 45,
+		},
+		{
+			// the great circle between two points on the same parallel bends towards the pole
+			name:        "GreatCircleHighLatitude",
+			a:           Location{LatitudeE7: 600000000, LongitudeE7: -300000000, Timestamp: start},
+			b:           Location{LatitudeE7: 600000000, LongitudeE7: 300000000, Timestamp: end},
+			t:           start.Add(30 * time.Minute),
+			expectedLat: 63.4349,
+			expectedLon: 0,
+		},
+		{
+			name:        "SamePoint",
+			a:           Location{LatitudeE7: 395107349, LongitudeE7: -91427899, Timestamp: start},
+			b:           Location{LatitudeE7: 395107349, LongitudeE7: -91427899, Timestamp: end},
+			t:           quarter,
+			expectedLat: 39.5107349,
+			expectedLon: -9.1427899,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := interpolateLocation(tt.a, tt.b, tt.t, tt.linear)
+			if math.Abs(result.latitude()-tt.expectedLat) > 1e-4 || math.Abs(result.longitude()-tt.expectedLon) > 1e-4 {
+				t.Errorf("Expected %v, %v, got %v, %v", tt.expectedLat, tt.expectedLon, result.latitude(), result.longitude())
+			}
+			if !result.Timestamp.Equal(tt.t) {
+				t.Errorf("Expected timestamp %v, got %v", tt.t, result.Timestamp)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+	"github.com/sirupsen/logrus"
+)
+
+// Every write is recorded in a journal, one JSON entry per line, so that the undo command can restore the files
+// a run modified as they were before it.
+
+type JournalEntry struct {
+	Run  string    `json:"run"`
+	Time time.Time `json:"time"`
+	File string    `json:"file"`
+	// sha256 of the file before and after the run. HashBefore is empty when the run created the file, e.g. an XMP sidecar
+	HashBefore string `json:"hashBefore,omitempty"`
+	HashAfter  string `json:"hashAfter"`
+	// values of the written fields before the run, the fields that weren't set are left out. When the run deleted
+	// the GPS tags the file already had, their values too
+	OldFields map[string]interface{} `json:"oldFields"`
+	NewFields map[string]interface{} `json:"newFields"`
+}
+
+// returns a new run id, from the time the run started
+func newRunID() string {
+	return time.Now().UTC().Format("20060102T150405.000Z")
+}
+
+// returns the --journal path, by default in the user's config directory
+func journalPath() string {
+	if *journalFile != "" {
+		return *journalFile
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "google-takeout-photo-location-fixer.journal"
+	}
+	return filepath.Join(dir, "google-takeout-photo-location-fixer", "journal.jsonl")
+}
+
+// returns the values the fields had in the file, the ones the file didn't have are left out.
+// The fields are matched without their group and exiftool's # suffix, the way exiftool reads them.
+func oldFieldValues(fileinfo exiftool.FileMetadata, fields map[string]interface{}) map[string]interface{} {
+	old := map[string]interface{}{}
+	for field := range fields {
+		if value, ok := fileinfo.Fields[tagName(field)]; ok && value != nil {
+			old[field] = value
+		}
+	}
+	return old
+}
+
+// returns the name of the tag a field writes, without its group and exiftool's # suffix
+func tagName(field string) string {
+	tag := strings.TrimSuffix(field, "#")
+	if separator := strings.LastIndex(tag, ":"); separator != -1 {
+		tag = tag[separator+1:]
+	}
+	return tag
+}
+
+// returns the fields that write back the values the file had before the run: the fields written get their old
+// value back or are deleted, and the other tags the run deleted, like an old GPSAltitude, get their old value back
+func undoFields(entry JournalEntry) map[string]interface{} {
+	fields := map[string]interface{}{}
+	written := map[string]bool{}
+	for field := range entry.NewFields {
+		// a nil value deletes the field
+		fields[field] = entry.OldFields[field]
+		written[tagName(field)] = true
+	}
+	for field, value := range entry.OldFields {
+		if _, ok := fields[field]; !ok && !written[tagName(field)] {
+			fields[field] = value
+		}
+	}
+	return fields
+}
+
+func appendJournal(path string, entries []JournalEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// reads the entries of the run from the journal
+func readJournal(path, run string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %v: %w", line, err)
+		}
+		if entry.Run == run {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func fileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// restores the files modified by the run: a file created by the run is removed, a file with an exiftool backup from
+// before the run gets it back, and the others get their old field values written back.
+// Files modified since the run are left alone. Returns the number of files restored and refused.
+func undoRun(et *exiftool.Exiftool, entries []JournalEntry) (restored int, refused int) {
+	toWrite := []exiftool.FileMetadata{}
+	for _, entry := range entries {
+		hash, err := fileHash(entry.File)
+		if err != nil {
+			logrus.Warnf("Can't undo the changes to %v: %v", entry.File, err)
+			refused++
+			continue
+		}
+		if hash != entry.HashAfter {
+			logrus.Warnf("Refusing to undo the changes to %v because it was modified since the run", entry.File)
+			refused++
+			continue
+		}
+
+		if entry.HashBefore == "" {
+			logrus.Debugf("Removing %v, created by the run", entry.File)
+			if !*dryRun {
+				if err := os.Remove(entry.File); err != nil {
+					logrus.Warnf("Error when removing %v: %v", entry.File, err)
+					refused++
+					continue
+				}
+			}
+			restored++
+			continue
+		}
+
+		backup := entry.File + "_original"
+		if backupHash, err := fileHash(backup); err == nil && backupHash == entry.HashBefore {
+			logrus.Debugf("Restoring %v from its backup", entry.File)
+			if !*dryRun {
+				if err := os.Rename(backup, entry.File); err != nil {
+					logrus.Warnf("Error when restoring the backup of %v: %v", entry.File, err)
+					refused++
+					continue
+				}
+			}
+			restored++
+			continue
+		}
+
+		toWrite = append(toWrite, exiftool.FileMetadata{File: entry.File, Fields: undoFields(entry)})
+	}
+
+	if !*dryRun && len(toWrite) > 0 {
+		et.WriteMetadata(toWrite)
+	}
+	for _, file := range toWrite {
+		if file.Err != nil {
+			logrus.Warnf("Error when writing back the old fields of %v: %v", file.File, file.Err)
+			refused++
+			continue
+		}
+		restored++
+	}
+	return restored, refused
+}
+
+// the undo command, restores the files modified by the --run
+func runUndo(et *exiftool.Exiftool) {
+	if *undoRunID == "" {
+		logrus.Fatalf("--run is required to undo a run, its id is logged at the end of the run")
+	}
+
+	entries, err := readJournal(journalPath(), *undoRunID)
+	if err != nil {
+		logrus.Fatalf("Error when reading the journal: %v", err)
+	}
+	if len(entries) == 0 {
+		logrus.Fatalf("No changes of run %v found in the journal %v", *undoRunID, journalPath())
+	}
+
+	logrus.Infof("Undoing the changes of run %v to %v files", *undoRunID, len(entries))
+	restored, refused := undoRun(et, entries)
+
+	logrus.Infof("Summary:")
+	logrus.Infof("\tFiles restored: %v", restored)
+	logrus.Infof("\tFiles not restored: %v", refused)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal", "journal.jsonl")
+
+	first := []JournalEntry{
+		{Run: "run1", File: "a.jpg", HashAfter: "1", NewFields: map[string]interface{}{"GPSLatitude": 39.5}},
+		{Run: "run1", File: "b.jpg", HashAfter: "2", OldFields: map[string]interface{}{"GPSAltitude": "12 m"}},
+	}
+	second := []JournalEntry{{Run: "run2", File: "a.jpg", HashAfter: "3"}}
+	for _, entries := range [][]JournalEntry{first, second} {
+		if err := appendJournal(path, entries); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	entries, err := readJournal(path, "run1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].File != "a.jpg" || entries[1].File != "b.jpg" {
+		t.Fatalf("Expected the 2 entries of run1, got %+v", entries)
+	}
+	if entries[0].NewFields["GPSLatitude"] != 39.5 || entries[1].OldFields["GPSAltitude"] != "12 m" {
+		t.Errorf("Expected the fields to be read back, got %+v", entries)
+	}
+
+	if entries, err := readJournal(path, "run3"); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries for an unknown run, got %+v: %v", entries, err)
+	}
+}
+
+func TestOldFieldValues(t *testing.T) {
+	fileinfo := exiftool.FileMetadata{Fields: map[string]interface{}{"GPSAltitude": "12 m", "GPSCoordinates": "39.5 -9.1", "Make": "Canon"}}
+	fields := map[string]interface{}{"GPSAltitude": 15.0, "GPSLatitude": 39.5, "Keys:GPSCoordinates#": "+39.5-009.1/"}
+
+	old := oldFieldValues(fileinfo, fields)
+	expected := map[string]interface{}{"GPSAltitude": "12 m", "Keys:GPSCoordinates#": "39.5 -9.1"}
+	if len(old) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, old)
+	}
+	for field, value := range expected {
+		if old[field] != value {
+			t.Errorf("Expected %v to be %v, got %v", field, value, old[field])
+		}
+	}
+}
+
+func TestUndoFields(t *testing.T) {
+	entry := JournalEntry{
+		NewFields: map[string]interface{}{"XMP:GPSLatitude": 39.5, "XMP:GPSLongitude": -9.1, "XMP:GPSAltitude": 15.0},
+		OldFields: map[string]interface{}{"XMP:GPSLatitude": "+0.00000000", "XMP:GPSLongitude": "+0.00000000", "GPSLatitude": "+0.00000000", "GPSImgDirection": "90"},
+	}
+
+	fields := undoFields(entry)
+	expected := map[string]interface{}{"XMP:GPSLatitude": "+0.00000000", "XMP:GPSLongitude": "+0.00000000", "XMP:GPSAltitude": nil, "GPSImgDirection": "90"}
+	if len(fields) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, fields)
+	}
+	for field, value := range expected {
+		if actual, ok := fields[field]; !ok || actual != value {
+			t.Errorf("Expected %v to be %v, got %v", field, value, actual)
+		}
+	}
+}
+
+func TestUndoRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) (string, string) {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		hash, err := fileHash(path)
+		if err != nil {
+			t.Fatalf("Failed to hash %v: %v", path, err)
+		}
+		return path, hash
+	}
+
+	created, createdHash := write("IMG_0001.xmp", "sidecar")
+	backedUp, originalHash := write("photo.jpg", "original")
+	os.Rename(backedUp, backedUp+"_original")
+	_, fixedHash := write("photo.jpg", "fixed")
+	modified, _ := write("modified.jpg", "modified since")
+
+	entries := []JournalEntry{
+		{Run: "run1", File: created, HashAfter: createdHash},
+		{Run: "run1", File: backedUp, HashBefore: originalHash, HashAfter: fixedHash},
+		{Run: "run1", File: modified, HashBefore: originalHash, HashAfter: fixedHash},
+		{Run: "run1", File: filepath.Join(tmpDir, "missing.jpg"), HashBefore: originalHash, HashAfter: fixedHash},
+	}
+
+	restored, refused := undoRun(nil, entries)
+	if restored != 2 || refused != 2 {
+		t.Errorf("Expected 2 files restored and 2 refused, got %v and %v", restored, refused)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("Expected the sidecar created by the run to be removed")
+	}
+	if content, _ := os.ReadFile(backedUp); string(content) != "original" {
+		t.Errorf("Expected the photo to be restored from its backup, got %q", content)
+	}
+	if content, _ := os.ReadFile(modified); string(content) != "modified since" {
+		t.Errorf("Expected the photo modified since the run to be left alone, got %q", content)
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,23 +20,151 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-type LocationFile struct {
-	Locations []Location `json:"locations"`
-}
-
 type Location struct {
 	LatitudeE7  int       `json:"latitudeE7"`
 	LongitudeE7 int       `json:"longitudeE7"`
 	Timestamp   time.Time `json:"timestamp"`
+	// estimated horizontal accuracy in metres, zero when unknown
+	Accuracy int `json:"accuracy"`
+	// how the location was determined: GPS, WIFI, CELL, etc. Empty when unknown
+	Source string `json:"source"`
+	// identifies the device that recorded the location, zero when unknown
+	DeviceTag int `json:"deviceTag"`
+	// metres above sea level, nil when unknown
+	Altitude *float64 `json:"altitude"`
+	// metres per second, nil when unknown
+	Velocity *float64 `json:"velocity"`
+	// direction of travel in degrees from true north, nil when unknown
+	Heading *float64 `json:"heading"`
+	// set for locations that cover a time interval (e.g. a place visit) instead of a single instant
+	EndTimestamp time.Time `json:"-"`
+	// priority of the source the location was read from, see --source-priority
+	Priority int `json:"-"`
+	// for a location interpolated between the points recorded before and after a time, how far that time is from the
+	// closest of them. It's how far in time the location really is from what was recorded
+	InterpolationGap time.Duration `json:"-"`
+	// the --location-records the location was read from. Near-identical locations are only de-duplicated when they
+	// come from different sources, the points of a single source are all kept
+	SourceFile string `json:"-"`
 }
 
 func locationLessFunc(a, b Location) bool {
-	return a.Timestamp.Before(b.Timestamp)
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	// keep a visit and a point starting at the same time apart, as well as different points recorded at the same time
+	if !a.EndTimestamp.Equal(b.EndTimestamp) {
+		return a.EndTimestamp.Before(b.EndTimestamp)
+	}
+	if a.LatitudeE7 != b.LatitudeE7 {
+		return a.LatitudeE7 < b.LatitudeE7
+	}
+	return a.LongitudeE7 < b.LongitudeE7
+}
+
+// reports whether a should be kept over b when both describe the same place at the same time.
+// The source priority decides first, then the better accuracy. On a tie the location read first is kept.
+func preferLocation(a, b Location) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.Accuracy != b.Accuracy {
+		return a.Accuracy > 0 && (b.Accuracy == 0 || a.Accuracy < b.Accuracy)
+	}
+	return false
 }
 
-var locationFile = flag.StringP("location-records", "f", "", "path to the Records.json from the Google Takeout")
-var photosDirectory = flag.StringP("photos-directory", "d", "", "path to the photos directory")
+// reports whether the location passes the --max-accuracy and --device-tag filters.
+// Locations with an unknown accuracy or device, like the ones from GPX tracks, are always kept.
+func isLocationAllowed(l Location) bool {
+	if *maxAccuracy > 0 && l.Accuracy > *maxAccuracy {
+		return false
+	}
+	if len(*deviceTags) > 0 && l.DeviceTag != 0 {
+		for _, tag := range *deviceTags {
+			if l.DeviceTag == tag {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ranks how the location was determined, higher is better
+func sourceRank(source string) int {
+	switch strings.ToUpper(source) {
+	case "GPS":
+		return 2
+	case "CELL":
+		return 0
+	}
+	return 1
+}
+
+// reports whether candidate a, differenceA away from the photo time, is a better match than b, differenceB away.
+// The closest one wins, unless they're within --prefer-gps-within of each other and one has a better source.
+func isBetterMatch(a Location, differenceA time.Duration, b Location, differenceB time.Duration) bool {
+	gap := differenceA - differenceB
+	if gap < 0 {
+		gap = -gap
+	}
+	if sourceRank(a.Source) != sourceRank(b.Source) && gap <= *gpsPreferenceWindow {
+		return sourceRank(a.Source) > sourceRank(b.Source)
+	}
+	return differenceA < differenceB
+}
+
+// returns how far the given time is from the location, zero if it falls inside the location's interval.
+// An interpolated location is as far as the closest of the points it was interpolated between
+func (l Location) timeDifference(t time.Time) time.Duration {
+	if l.EndTimestamp.IsZero() || t.Before(l.Timestamp) {
+		return getUnsignedDateDifference(l.Timestamp, t) + l.InterpolationGap
+	}
+	if t.After(l.EndTimestamp) {
+		return t.Sub(l.EndTimestamp)
+	}
+	return 0
+}
+
+// the duration of the longest interval location read so far, used to find intervals that started before the tolerance window
+var longestInterval time.Duration
+
+var locationFiles = flag.StringArrayP("location-records", "f", nil, "path to the Records.json, the Semantic Location History directory or a .zip/.tgz archive from the Google Takeout, the Timeline.json exported from the phone, or a GPX, KML or GeoJSON track. Can be repeated to merge several sources")
+var sourcePriority = flag.StringToInt("source-priority", map[string]int{}, "priority of a --location-records source, as path=priority. When sources have near-identical points, the one with the highest priority is kept (default 0)")
+var dedupWindow = flag.Duration("dedup-window", 5*time.Second, "points from different location sources closer than this in time and --dedup-distance in space are de-duplicated, the points of a single source are all kept. 0 disables de-duplication")
+var dedupDistance = flag.Float64("dedup-distance", 25, "distance in metres under which points within --dedup-window are considered duplicates")
+var photosDirectories = flag.StringArrayP("photos-directory", "d", nil, "path to the photos directory or a .zip/.tgz Takeout archive. Can be repeated, e.g. for each part of a Takeout")
+var outputDir = flag.StringP("output-dir", "o", "", "directory where the fixed copies of the photos are written, mirroring the structure of the photos directories, which are left untouched. Required for photos read from archives")
+var copyUnchanged = flag.String("copy-unchanged", "none", "what to do with the photos that don't get a location when using --output-dir: none to leave them out, hardlink or copy to have them in the output directory too")
 var tolerance = flag.DurationP("tolerance", "t", 1*time.Hour, "tolerance for the date to find (e.g. 1h, 30m, 1h30m, 1h30m30s, etc.)")
+var maxAccuracy = flag.Int("max-accuracy", 0, "ignore locations with an accuracy worse than this, in metres. 0 keeps all locations")
+var deviceTags = flag.IntSlice("device-tag", nil, "only use locations recorded by the devices with these deviceTag values from Records.json, e.g. to leave out a work phone. Locations without a device tag are always used")
+var gpsPreferenceWindow = flag.Duration("prefer-gps-within", 0, "prefer a GPS location over a WIFI or CELL one that is at most this much closer in time to the photo. Equally close locations always prefer GPS")
+var interpolate = flag.String("interpolate", "", "interpolate between the locations recorded just before and just after the photo instead of using the closest one: linear or great-circle (default great-circle when no value is given)")
+var maxInterpolationGap = flag.Duration("max-interpolation-gap", 1*time.Hour, "don't interpolate between locations recorded further apart than this")
+var maxInterpolationSpeed = flag.Float64("max-interpolation-speed", 300, "don't interpolate between locations when getting from one to the other implies a speed over this, in km/h")
+var timezoneName = flag.String("timezone", "UTC", "time zone the camera clock was set to, used for photos without OffsetTimeOriginal or OffsetTime (e.g. Europe/Lisbon, America/New_York, Local)")
+var timezoneBoundariesFile = flag.String("timezone-boundaries", "", "path to a time zone boundaries GeoJSON (e.g. combined.geojson from timezone-boundary-builder) to find the time zone of the location found for photos without an offset. nearest to approximate it with the zone whose bundled reference city is the closest, which is wrong for whole regions near the borders between zones")
+var sidecarLocation = flag.Bool("sidecar-location", false, "use the location in the Google Photos JSON sidecar of a photo (photo.jpg.json or photo.jpg.supplemental-metadata.json) when it has one, instead of the location history")
+var output = flag.String("output", "file", "where the location is written: file to write it to the photos themselves, or sidecar to leave them untouched and write it to XMP sidecars next to them, as done for raw files")
+var xmpSidecarName = flag.String("xmp-sidecar-name", "base", "how to name new XMP sidecars of raw files: base for IMG_0001.xmp (Lightroom) or full for IMG_0001.CR2.xmp (darktable, digiKam). Existing sidecars are used whatever their name")
+var videoMidClip = flag.Bool("video-mid-clip", false, "match videos to the location at the middle of the clip instead of the one where the recording started")
+var timeSources = flag.StringSlice("time-sources", defaultTimeSources, "where to read the time a photo was taken from, in order: DateTimeOriginal, SubSecDateTimeOriginal, CreateDate, ModifyDate, sidecar (the photoTakenTime of the Takeout JSON), filename (e.g. IMG_20190419_200128.jpg) and mtime. The first one that is set is used")
+var cameraOffsets = flag.StringArray("camera-offset", nil, "offset to add to the capture time of the photos taken by a camera, as Make/Model=offset (e.g. \"Canon/Canon EOS 80D=+1h23m\"). Can be repeated for each camera. The estimate-offset command suggests the values to use")
+var maxOffset = flag.Duration("max-offset", 12*time.Hour, "estimate-offset tries camera offsets between minus and plus this value")
+var offsetStep = flag.Duration("offset-step", 1*time.Minute, "estimate-offset tries camera offsets in steps of this value")
+var offsetMatchDistance = flag.Float64("offset-match-distance", 200, "estimate-offset counts a photo as matching the location history when the location found for it is within this distance in metres of its GPS metadata")
+var overwrite = flag.String("overwrite", "never", "what to do with the photos that already have GPS metadata: never to skip them, always to replace it, if-zero to replace it when it's 0,0 or if-worse to replace it when the location found is more accurate than its GPSHPositioningError. Photos with only a latitude or a longitude are always repaired")
+var outlierDistance = flag.Float64("outlier-distance", 1000, "the audit command flags the photos whose GPS metadata is further than this distance in metres from the location history")
+var fixOutliers = flag.Bool("fix-outliers", false, "make the audit command write the location history position to the photos it flags, instead of only listing them")
+var journalFile = flag.String("journal", "", "path to the journal where the changes of every run are recorded for the undo command (default journal.jsonl in the google-takeout-photo-location-fixer directory of the user's config directory)")
+var undoRunID = flag.String("run", "", "id of the run to undo with the undo command")
+var stateFile = flag.String("state", "", "path to the state file that records the files processed, for reruns to only process new or changed files and resume interrupted runs (default .google-takeout-photo-location-fixer-state.jsonl in --output-dir, none without it)")
+var retryUndecided = flag.Bool("retry-undecided", false, "process again the files the state file records without a date time or a location, e.g. after adding location history")
+var maxErrors = flag.Int("max-errors", 0, "abort the run when more than this number of files can't be read or written. 0 doesn't limit the errors, the files that fail are skipped and listed at the end of the run")
+var reportFile = flag.String("report", "", "path to a report.json or report.csv where the decision about every file found is written, with its capture time and the location found for it")
+var mapFile = flag.String("map", "", "path to an HTML map of the photos about to be written, with their thumbnails and the location history around them, written before the confirmation prompt. It works offline, without map tiles")
 var exiftoolBinary = flag.String("exiftool-binary", "", "path to the exiftool binary. If not specified, will try to find the binary in the $PATH")
 var skipBackup = flag.Bool("skip-backup", false, "skip backup of the photos before modifying them")
 var skipPrompt = flag.BoolP("skip-promt", "y", false, "skip the prompt before modifying the photos")
@@ -41,20 +172,100 @@ var dryRun = flag.BoolP("dry-run", "n", false, "skips all write operations")
 var verbose = flag.BoolP("verbose", "v", false, "verbose output")
 
 func main() {
+	// set when files failed, deferred first to exit after the other deferred calls
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	flag.CommandLine.AddGoFlagSet(goflag.CommandLine)
+	flag.Lookup("interpolate").NoOptDefVal = "great-circle"
 	flag.Parse()
 
 	if *verbose {
 		logrus.SetLevel(logrus.TraceLevel)
 	}
 
-	et, err := setupExiftool()
+	command := flag.Arg(0)
+	if command != "" && command != "estimate-offset" && command != "audit" && command != "undo" {
+		logrus.Fatalf("Unknown command %v, must be estimate-offset, audit or undo", command)
+	}
+	if command == "estimate-offset" && *offsetStep <= 0 {
+		logrus.Fatalf("--offset-step must be positive")
+	}
+
+	// undoing restores the files as they were, there's nothing to back up
+	et, err := setupExiftool(command != "undo")
 	if err != nil {
 		logrus.Fatalf("Error when setting up exiftool: %v", err)
 	}
 	defer et.Close()
 
-	locations, err := readLocations(*locationFile)
+	if command == "undo" {
+		runUndo(et)
+		return
+	}
+
+	if *interpolate != "" && *interpolate != "linear" && *interpolate != "great-circle" {
+		logrus.Fatalf("Invalid --interpolate %v, must be linear or great-circle", *interpolate)
+	}
+
+	timezone, err := time.LoadLocation(*timezoneName)
+	if err != nil {
+		logrus.Fatalf("Invalid --timezone: %v", err)
+	}
+
+	if err := validateTimeSources(*timeSources); err != nil {
+		logrus.Fatalf("Invalid --time-sources: %v", err)
+	}
+
+	if *output != "file" && *output != "sidecar" {
+		logrus.Fatalf("Invalid --output %v, must be file or sidecar", *output)
+	}
+	if *copyUnchanged != "none" && *copyUnchanged != "hardlink" && *copyUnchanged != "copy" {
+		logrus.Fatalf("Invalid --copy-unchanged %v, must be none, hardlink or copy", *copyUnchanged)
+	}
+	if *xmpSidecarName != "base" && *xmpSidecarName != "full" {
+		logrus.Fatalf("Invalid --xmp-sidecar-name %v, must be base or full", *xmpSidecarName)
+	}
+	if *overwrite != overwriteNever && *overwrite != overwriteAlways && *overwrite != overwriteIfWorse && *overwrite != overwriteIfZero {
+		logrus.Fatalf("Invalid --overwrite %v, must be never, always, if-worse or if-zero", *overwrite)
+	}
+	if *reportFile != "" {
+		if err := validateReportPath(*reportFile); err != nil {
+			logrus.Fatalf("Invalid --report: %v", err)
+		}
+	}
+
+	offsetsByCamera, err := parseCameraOffsets(*cameraOffsets)
+	if err != nil {
+		logrus.Fatalf("Invalid --camera-offset: %v", err)
+	}
+
+	var timezoneBoundaries *TimezoneBoundaries
+	switch *timezoneBoundariesFile {
+	case "":
+		// every photo without an offset is read in --timezone
+	case "nearest":
+		logrus.Infof("The time zone of the photos without an offset is approximated with the closest reference city of each zone")
+		timezoneBoundaries, err = readEmbeddedTimezoneBoundaries()
+	default:
+		timezoneBoundaries, err = readTimezoneBoundaries(*timezoneBoundariesFile)
+	}
+	if err != nil {
+		logrus.Fatalf("Error when reading time zone boundaries: %v", err)
+	}
+
+	if len(*locationFiles) == 0 {
+		logrus.Fatalf("At least one --location-records is required")
+	}
+	if len(*photosDirectories) == 0 {
+		logrus.Fatalf("At least one --photos-directory is required")
+	}
+
+	locations, err := readLocations(*locationFiles...)
 
 	if err != nil {
 		logrus.Fatalf("Error when reading locations: %v", err)
@@ -62,80 +273,346 @@ func main() {
 
 	logrus.Infof("Read %v GPS locations", locations.Len())
 	unsupportedExtensions := map[string]int{}
+	formatCounters := map[string]int{}
 	filesToProcess := []string{}
+	unsupportedFiles := []string{}
+	archivePhotos := ArchivePhotos{}
+	mirrored := mirroredTargets{}
+	// the files that failed, starting with the directories that can't be read while walking the photos directories
+	fileErrors := &FileErrors{max: *maxErrors}
 
-	err = filepath.WalkDir(*photosDirectory, func(path string, d os.DirEntry, err error) error {
+	var state *StateStore
+	// the state is of the photos without GPS metadata, the audit command fixes photos that have it
+	if path := statePath(); path != "" && !*dryRun && command == "" {
+		state, err = openStateStore(path)
 		if err != nil {
-			return err
-		}
-		if d.Type().IsDir() {
-			return nil
+			logrus.Fatalf("Error when reading the state file: %v", err)
 		}
+		defer state.Close()
+	}
+	// the decisions recorded in the state that are made again
+	retryDecisions := map[string]bool{decisionReadError: true, decisionWriteError: true}
+	if *retryUndecided {
+		retryDecisions[decisionNoDateTime], retryDecisions[decisionNoLocation] = true, true
+	}
+	if *overwrite != overwriteNever {
+		retryDecisions[decisionGPSAlreadySet] = true
+	}
 
-		extension := strings.ToLower(filepath.Ext(d.Name()))
-		if extension != ".jpg" && extension != ".jpeg" {
-			unsupportedExtensions[extension]++
-			return nil
+	for _, photosDirectory := range *photosDirectories {
+		if isArchive(photosDirectory) {
+			// only the photos that get a location are extracted, to the output directory
+			writes := !*dryRun && (command == "" || (command == "audit" && *fixOutliers))
+			if writes && *outputDir == "" {
+				logrus.Fatalf("--output-dir is required to process photos from the archive %v", photosDirectory)
+			}
+			if archiveDirectory == "" {
+				scratchDir, err := os.MkdirTemp("", "google-takeout-photo-location-fixer")
+				if err != nil {
+					logrus.Fatalf("Error when creating temporary directory: %v", err)
+				}
+				defer os.RemoveAll(scratchDir)
+				// logrus.Fatalf exits without running the deferred calls, when the run is aborted too
+				logrus.RegisterExitHandler(func() { os.RemoveAll(scratchDir) })
+				archiveDirectory = filepath.Join(scratchDir, "archives")
+			}
+
+			err = walkArchive(photosDirectory, func(name string, size int64, modTime time.Time, reader io.Reader) error {
+				extension := strings.ToLower(filepath.Ext(name))
+				// the sidecars are extracted next to where the photos would be, to be found the same way
+				if isSidecarEntry(name) {
+					_, err := extractArchiveEntry(archiveDirectory, name, reader)
+					return err
+				}
+				handler := mediaHandlerForExtension(extension)
+				if handler == nil {
+					unsupportedExtensions[extension]++
+					unsupportedFiles = append(unsupportedFiles, filepath.Join(photosDirectory, filepath.FromSlash(name)))
+					return nil
+				}
+
+				photo := &ArchivePhoto{Archive: photosDirectory, Name: name, Path: archiveEntryPath(archiveDirectory, name), Size: size, ModTime: modTime}
+				if _, ok := archivePhotos[photo.Path]; ok {
+					logrus.Warnf("Skipping %v in %v because another archive has a photo at the same path", name, photosDirectory)
+					return nil
+				}
+				if !mirrored.claim(photo.Path) {
+					logrus.Warnf("Skipping %v in %v because another photos directory has a photo at the same path", name, photosDirectory)
+					return nil
+				}
+				archivePhotos[photo.Path] = photo
+				filesToProcess = append(filesToProcess, photo.Path)
+				formatCounters[handler.Name]++
+				if state.isArchivePhotoDone(photo, retryDecisions) {
+					return nil
+				}
+				if err := photo.read(et, filepath.Dir(archiveDirectory), reader); err != nil {
+					// reported with the read failures when its metadata is extracted, like a photo exiftool can't read
+					photo.Metadata = &exiftool.FileMetadata{File: photo.Path, Err: err}
+					return nil
+				}
+				if state.isArchivePhotoDone(photo, retryDecisions) {
+					photo.Metadata = nil
+				}
+				return nil
+			})
+			if err != nil {
+				logrus.Fatalf("Error when reading archive %v: %v", photosDirectory, err)
+			}
+			continue
 		}
 
-		filesToProcess = append(filesToProcess, path)
+		err = filepath.WalkDir(photosDirectory, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				if path == photosDirectory {
+					return err
+				}
+				logrus.Warnf("Skipping %v because of an error when reading it: %v", path, err)
+				fileErrors.add(path, "read", err)
+				return nil
+			}
+			if d.Type().IsDir() {
+				// the output directory may be inside the photos directory, its copies are not photos to fix
+				if *outputDir != "" && sameFile(path, *outputDir) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
 
-		return nil
-	})
-	if err != nil {
-		logrus.Fatalf("Error when walking directory: %v", err)
+			extension := strings.ToLower(filepath.Ext(d.Name()))
+			handler := mediaHandlerForExtension(extension)
+			if handler == nil {
+				unsupportedExtensions[extension]++
+				unsupportedFiles = append(unsupportedFiles, path)
+				return nil
+			}
+			if !mirrored.claim(path) {
+				logrus.Warnf("Skipping %v because another photos directory has a photo at the same path", path)
+				return nil
+			}
+
+			filesToProcess = append(filesToProcess, path)
+			formatCounters[handler.Name]++
+
+			return nil
+		})
+		if err != nil {
+			logrus.Fatalf("Error when walking directory: %v", err)
+		}
 	}
 
 	logrus.Infof("Found:")
 	logrus.Infof("\tUnsupported extensions: %v", unsupportedExtensions)
 	logrus.Infof("\tFiles with supported extensions: %v", len(filesToProcess))
+	logrus.Infof("\tFiles by format: %v", formatCounters)
 
-	logrus.Infof("Starting the exif read operation and backups")
-
-	noLocationFoundCounter, noDateTimeCounter, gpsMetadataAlreadySetCounter := 0, 0, 0
-
-	files := et.ExtractMetadata(filesToProcess...)
-	filesPreparedToWrite := []exiftool.FileMetadata{}
-	for _, fileinfo := range files {
-		if fileinfo.Err != nil {
-			logrus.Fatalf("Error when extracting metadata: %v\n", fileinfo.Err)
+	if command == "estimate-offset" {
+		runEstimateOffset(et, locations, filesToProcess, archivePhotos, timezone, timezoneBoundaries)
+		return
+	}
+	if command == "audit" {
+		outliers := runAudit(et, locations, filesToProcess, archivePhotos, timezone, timezoneBoundaries, offsetsByCamera)
+		if !*fixOutliers || len(outliers) == 0 {
+			return
 		}
+		// the outliers are fixed like photos without GPS metadata
+		logrus.Infof("Fixing the %v files further than %vm from the location history", len(outliers), *outlierDistance)
+		filesToProcess = outliers
+		unsupportedFiles = nil
+	}
 
-		if fileinfo.Fields["GPSLatitude"] != nil || fileinfo.Fields["GPSLongitude"] != nil {
-			logrus.Debugf("Skipping file %v because it already has GPS metadata", fileinfo.File)
-			gpsMetadataAlreadySetCounter++
-			continue
+	runID := newRunID()
+	recordState := func(path, decision string) {
+		var err error
+		if photo, ok := archivePhotos[path]; ok {
+			err = state.recordArchivePhoto(photo, decision, runID)
+		} else {
+			err = state.record(path, decision, runID)
 		}
-
-		dateTimeOriginal, err := fileinfo.GetString("DateTimeOriginal")
 		if err != nil {
-			logrus.Warnf("Skipping file %v because we couldn't determine the time the photo was taken: %v", fileinfo.File, err)
-			noDateTimeCounter++
-			continue
+			logrus.Warnf("Error when recording the state of file %v: %v", path, err)
 		}
-		dtParse, err := time.Parse("2006:01:02 15:04:05", dateTimeOriginal)
+	}
+
+	report := []ReportEntry{}
+	reportDecision := func(entry ReportEntry, decision string) {
+		entry.Decision = decision
+		report = append(report, entry)
+	}
+	for _, path := range unsupportedFiles {
+		reportDecision(ReportEntry{File: path}, reportUnsupported)
+	}
+	for _, fileError := range fileErrors.errors {
+		reportDecision(ReportEntry{File: fileError.File, Reason: fileError.Reason}, reportError)
+	}
+	// the report is written as it is on every way out of the run: before the prompt, when the run is aborted, and at the end
+	saveReport := func() error {
+		if *reportFile == "" {
+			return nil
+		}
+		err := writeReport(*reportFile, report)
 		if err != nil {
-			logrus.Warnf("Skipping file %v because we couldn't parse the time the photo was taken: %v", fileinfo.File, err)
-			noDateTimeCounter++
-			continue
+			logrus.Errorf("Error when writing the report: %v", err)
+			exitCode = 1
 		}
+		return err
+	}
+	// aborts the run, saving the report first
+	abort := func(format string, args ...interface{}) {
+		saveReport()
+		logrus.Fatalf(format, args...)
+	}
 
-		location := findLocationFromDate(locations, dtParse)
-		if location == nil {
-			logrus.Warnf("No location found within the defined tolerance for file %v", fileinfo.File)
-			noLocationFoundCounter++
-			continue
+	filesToRead := []string{}
+	for _, path := range filesToProcess {
+		done := state.isDone(path, retryDecisions)
+		if photo, ok := archivePhotos[path]; ok {
+			done = photo.Metadata == nil
+		}
+		if !done {
+			filesToRead = append(filesToRead, path)
+		} else {
+			reportDecision(ReportEntry{File: path}, reportUnchanged)
 		}
-		latitude := float32(location.LatitudeE7) / 10000000
-		longitude := float32(location.LongitudeE7) / 10000000
-		logrus.Debugf("Found location for file %v: %v, %v", fileinfo.File, latitude, longitude)
+	}
+	if state != nil {
+		logrus.Infof("\tFiles unchanged since they were processed: %v", len(filesToProcess)-len(filesToRead))
+	}
 
-		fileinfo.Fields["GPSLatitude"] = latitude
-		fileinfo.Fields["GPSLatitudeRef"] = latitude
-		fileinfo.Fields["GPSLongitude"] = longitude
-		fileinfo.Fields["GPSLongitudeRef"] = longitude
+	abortOnTooManyErrors := func() {
+		if fileErrors.tooMany() {
+			fileErrors.log()
+			abort("Aborting because more than %v files failed, see --max-errors", *maxErrors)
+		}
+	}
+	abortOnTooManyErrors()
 
-		filesPreparedToWrite = append(filesPreparedToWrite, fileinfo)
+	logrus.Infof("Starting the exif read operation and backups")
+
+	noLocationFoundCounter, noDateTimeCounter, gpsMetadataAlreadySetCounter, previousOutputCounter := 0, 0, 0, 0
+	timeSourceCounters := map[string]int{}
+	sidecarLocationCounter := 0
+
+	sidecarsWithGPS := readSidecarsWithGPS(et, filesToRead)
+	filesPreparedToWrite := []exiftool.FileMetadata{}
+	// the file each of the files prepared to write was read from, its journal entry, its index in the report and
+	// whether its GPS tags are deleted before writing
+	preparedSources := []string{}
+	clearGPS := []bool{}
+	journalEntries := []JournalEntry{}
+	preparedReports := []int{}
+	mapPhotos := []MapPhoto{}
+	for batchStart := 0; batchStart < len(filesToRead); batchStart += batchSize {
+		for _, fileinfo := range archivePhotos.extractMetadata(et, filesToRead[batchStart:min(batchStart+batchSize, len(filesToRead))]...) {
+			entry := ReportEntry{File: fileinfo.File}
+			if fileinfo.Err != nil {
+				logrus.Warnf("Skipping file %v because of an error when extracting metadata: %v", fileinfo.File, fileinfo.Err)
+				fileErrors.add(fileinfo.File, "read", fileinfo.Err)
+				entry.Reason = fileinfo.Err.Error()
+				reportDecision(entry, reportError)
+				recordState(fileinfo.File, decisionReadError)
+				abortOnTooManyErrors()
+				continue
+			}
+
+			// the GPS metadata of the file, or of its sidecar when the file has none. The audit command replaces it
+			existingGPS := fileinfo
+			if hasGPS, _ := gpsMetadataState(fileinfo); !hasGPS {
+				if sidecar, ok := sidecarsWithGPS[xmpSidecarPath(fileinfo.File)]; ok {
+					existingGPS = sidecar
+				}
+			}
+			if command != "audit" && keepExistingGPS(existingGPS, nil) {
+				logrus.Debugf("Skipping file %v because %v already has GPS metadata", fileinfo.File, existingGPS.File)
+				gpsMetadataAlreadySetCounter++
+				recordState(fileinfo.File, decisionGPSAlreadySet)
+				reportDecision(entry, reportSkippedHasGPS)
+				continue
+			}
+			if hasGPS, complete := gpsMetadataState(existingGPS); hasGPS && !complete {
+				logrus.Debugf("Repairing the half-populated GPS metadata of %v", existingGPS.File)
+			}
+
+			captureTime, hasOffset, timeSource, err := readCaptureTime(fileinfo, fileTimeSources(fileinfo.File, archivePhotos), timezone)
+			if err != nil {
+				logrus.Warnf("Skipping file %v because we couldn't determine the time the photo was taken: %v", fileinfo.File, err)
+				noDateTimeCounter++
+				recordState(fileinfo.File, decisionNoDateTime)
+				entry.Reason = err.Error()
+				reportDecision(entry, reportNoDate)
+				continue
+			}
+			logrus.Debugf("Read the capture time of file %v from %v", fileinfo.File, timeSource)
+			timeSourceCounters[timeSource]++
+			if cameraOffset, ok := offsetsByCamera[cameraName(fileinfo)]; ok {
+				logrus.Tracef("Applying the %v offset of camera %v to file %v", cameraOffset, cameraName(fileinfo), fileinfo.File)
+				captureTime = captureTime.Add(cameraOffset)
+			}
+			handler := mediaHandlerFor(fileinfo.File)
+			if handler.Video && *videoMidClip {
+				if duration, ok := videoDuration(fileinfo); ok {
+					captureTime = captureTime.Add(duration / 2)
+				}
+			}
+
+			var location *Location
+			if *sidecarLocation {
+				location = findSidecarLocation(fileinfo.File, captureTime)
+			}
+			if location != nil {
+				logrus.Debugf("Using the location in the sidecar of file %v", fileinfo.File)
+				sidecarLocationCounter++
+			} else if hasOffset {
+				location = findLocationFromDate(locations, captureTime)
+			} else {
+				location, captureTime = findLocationFromLocalTime(locations, captureTime, timezoneBoundaries)
+			}
+			logrus.Tracef("File %v was taken at %v", fileinfo.File, captureTime)
+			entry.CaptureTime = &captureTime
+			entry.TimeSource = timeSource
+			if location == nil {
+				logrus.Warnf("No location found within the defined tolerance for file %v", fileinfo.File)
+				noLocationFoundCounter++
+				recordState(fileinfo.File, decisionNoLocation)
+				reportDecision(entry, reportNoLocation)
+				continue
+			}
+			logrus.Debugf("Found location for file %v: %v, %v", fileinfo.File, location.latitude(), location.longitude())
+			if command != "audit" && keepExistingGPS(existingGPS, location) {
+				logrus.Debugf("Skipping file %v because the GPS metadata of %v is more accurate than the location found", fileinfo.File, existingGPS.File)
+				gpsMetadataAlreadySetCounter++
+				recordState(fileinfo.File, decisionGPSAlreadySet)
+				reportDecision(entry, reportSkippedHasGPS)
+				continue
+			}
+
+			target := fileinfo
+			if handler.usesXMPSidecar() {
+				target = sidecarsWithGPS[xmpSidecarPath(fileinfo.File)]
+			}
+			write, oldFields, clear := prepareGPSWrite(handler, fileinfo, target, *location)
+			sourceHash := ""
+			if photo, ok := archivePhotos[fileinfo.File]; ok {
+				sourceHash = photo.Hash
+			}
+			if hasPreviousOutput(fileinfo.File, sourceHash) || (write.File != fileinfo.File && hasPreviousOutput(write.File, "")) {
+				logrus.Warnf("Skipping file %v because the output directory has a different copy of it, left by a previous run", fileinfo.File)
+				previousOutputCounter++
+				reportDecision(entry, reportSkippedOutputExists)
+				continue
+			}
+			journalEntries = append(journalEntries, JournalEntry{Run: runID, OldFields: oldFields, NewFields: write.Fields})
+			clearGPS = append(clearGPS, clear)
+
+			entry.setLocation(*location, captureTime)
+			if *mapFile != "" {
+				mapPhotos = append(mapPhotos, newMapPhoto(fileinfo.File, *location, captureTime, locations))
+			}
+			preparedReports = append(preparedReports, len(report))
+			reportDecision(entry, reportWouldWrite)
+			preparedSources = append(preparedSources, fileinfo.File)
+			filesPreparedToWrite = append(filesPreparedToWrite, write)
+		}
 	}
 
 	logrus.Infof("Finished the exif read operation")
@@ -145,13 +622,35 @@ func main() {
 	logrus.Infof("\tFiles with no location found: %v", noLocationFoundCounter)
 	logrus.Infof("\tFiles with no date time found: %v", noDateTimeCounter)
 	logrus.Infof("\tFiles with GPS metadata already set: %v", gpsMetadataAlreadySetCounter)
+	if previousOutputCounter > 0 {
+		logrus.Infof("\tFiles with a different copy in the output directory: %v", previousOutputCounter)
+	}
+	logrus.Infof("\tCapture time sources: %v", timeSourceCounters)
+	if *sidecarLocation {
+		logrus.Infof("\tFiles located from their sidecar: %v", sidecarLocationCounter)
+	}
+	logrus.Infof("\tFiles with read failure: %v", fileErrors.count("read"))
+
+	if *mapFile != "" {
+		if err := readThumbnails(mapPhotos); err != nil {
+			logrus.Warnf("Error when reading the thumbnails for the map, it will have none: %v", err)
+		}
+		if err := writeMap(*mapFile, mapPhotos); err != nil {
+			logrus.Errorf("Error when writing the map: %v", err)
+		} else {
+			logrus.Infof("The photos about to be written were plotted on the map %v", *mapFile)
+		}
+	}
 
 	if !*skipPrompt {
+		if *reportFile != "" && saveReport() == nil {
+			logrus.Infof("The files that would be written are listed in the report %v", *reportFile)
+		}
 		logrus.Infof("%v files will be modified. Do you wish to proceed? (Yes/No)", len(filesPreparedToWrite))
 
 		if !requestConfirmation() {
 			logrus.Infof("Aborting.")
-			os.Exit(0)
+			return
 		}
 	} else {
 		logrus.Infof("Skipping confirmation prompt.")
@@ -159,18 +658,91 @@ func main() {
 
 	logrus.Infof("Starting the exif rewrite operation")
 
-	errorWriteCounter := 0
 	successfulWriteCounter := 0
 
 	if !*dryRun {
-		et.WriteMetadata(filesPreparedToWrite)
+		written := map[string]bool{}
 		for _, v := range filesPreparedToWrite {
-			if v.Err != nil {
-				logrus.Warnf("Error when writing metadata for file %v: %v", v.File, v.Err)
-				errorWriteCounter++
-			} else {
+			written[v.File] = true
+		}
+		photosToExtract := []*ArchivePhoto{}
+		for _, source := range preparedSources {
+			if photo, ok := archivePhotos[source]; ok {
+				photosToExtract = append(photosToExtract, photo)
+			}
+		}
+		extractedCopies, err := extractArchivePhotos(photosToExtract)
+		if err != nil {
+			removeFailedCopies(extractedCopies, nil)
+			abort("Error when extracting photos to the output directory: %v", err)
+		}
+		if err := mirrorFilesToWrite(filesPreparedToWrite); err != nil {
+			abort("Error when copying photos to the output directory: %v", err)
+		}
+		for i, v := range filesPreparedToWrite {
+			journalEntries[i].File = v.File
+			// files that don't exist yet, or were just extracted from an archive, are created by the run
+			if extractedCopies[v.File] {
+				continue
+			}
+			if hash, err := fileHash(v.File); err == nil {
+				journalEntries[i].HashBefore = hash
+			}
+		}
+		if err := createMissingXMPSidecars(filesPreparedToWrite); err != nil {
+			abort("Error when creating XMP sidecar: %v", err)
+		}
+
+		journaled := 0
+		for batchStart := 0; batchStart < len(filesPreparedToWrite); batchStart += batchSize {
+			batchEnd := min(batchStart+batchSize, len(filesPreparedToWrite))
+			writeGPSMetadata(et, filesPreparedToWrite[batchStart:batchEnd], clearGPS[batchStart:batchEnd])
+
+			journal := []JournalEntry{}
+			for i := batchStart; i < batchEnd; i++ {
+				v := filesPreparedToWrite[i]
+				if v.Err != nil {
+					logrus.Warnf("Error when writing metadata for file %v: %v", v.File, v.Err)
+					fileErrors.add(v.File, "write", v.Err)
+					recordState(preparedSources[i], decisionWriteError)
+					report[preparedReports[i]].Decision = reportError
+					report[preparedReports[i]].Reason = v.Err.Error()
+					continue
+				}
 				successfulWriteCounter++
+				recordState(preparedSources[i], decisionWritten)
+				report[preparedReports[i]].Decision = reportWritten
+
+				hash, err := fileHash(v.File)
+				if err != nil {
+					logrus.Warnf("Error when hashing %v for the journal, the changes to it can't be undone: %v", v.File, err)
+					continue
+				}
+				journalEntries[i].HashAfter = hash
+				journalEntries[i].Time = time.Now().UTC()
+				journal = append(journal, journalEntries[i])
+			}
+			if err := appendJournal(journalPath(), journal); err != nil {
+				logrus.Errorf("Error when writing the journal, the changes of this run can't be undone: %v", err)
+			} else {
+				journaled += len(journal)
 			}
+			// the batch written is journaled before aborting, for it to be undone
+			abortOnTooManyErrors()
+		}
+		if journaled > 0 {
+			logrus.Infof("The changes were recorded as run %v, they can be undone with: undo --run %v", runID, runID)
+		}
+		removeFailedCopies(extractedCopies, filesPreparedToWrite)
+		// the photos in archives that aren't written aren't extracted
+		filesOnDisk := []string{}
+		for _, path := range filesToProcess {
+			if _, ok := archivePhotos[path]; !ok {
+				filesOnDisk = append(filesOnDisk, path)
+			}
+		}
+		if err := mirrorUnchangedFiles(filesOnDisk, written); err != nil {
+			abort("Error when copying unchanged photos to the output directory: %v", err)
 		}
 	}
 
@@ -181,15 +753,67 @@ func main() {
 	logrus.Infof("\tFiles with no location found: %v", noLocationFoundCounter)
 	logrus.Infof("\tFiles with no date time found: %v", noDateTimeCounter)
 	logrus.Infof("\tFiles with GPS metadata already set: %v", gpsMetadataAlreadySetCounter)
-	logrus.Infof("\tFiles with write failure: %v", errorWriteCounter)
+	if previousOutputCounter > 0 {
+		logrus.Infof("\tFiles with a different copy in the output directory: %v", previousOutputCounter)
+	}
+	logrus.Infof("\tCapture time sources: %v", timeSourceCounters)
+	if *sidecarLocation {
+		logrus.Infof("\tFiles located from their sidecar: %v", sidecarLocationCounter)
+	}
+	logrus.Infof("\tFiles with read failure: %v", fileErrors.count("read"))
+	logrus.Infof("\tFiles with write failure: %v", fileErrors.count("write"))
+
+	if *reportFile != "" && saveReport() == nil {
+		logrus.Infof("The decision about every file was written to %v", *reportFile)
+	}
+
+	if len(fileErrors.errors) > 0 {
+		fileErrors.log()
+		exitCode = 1
+	}
 }
 
-func setupExiftool() (*exiftool.Exiftool, error) {
-	exiftoolOpts := [](func(*exiftool.Exiftool) error){}
+// sets the exif GPS tags for the location. Altitude, speed and heading are only set when the location has them
+func setGPSFields(fields map[string]interface{}, location Location) {
+	latitude := float32(location.LatitudeE7) / 10000000
+	longitude := float32(location.LongitudeE7) / 10000000
+
+	fields["GPSLatitude"] = latitude
+	fields["GPSLatitudeRef"] = latitude
+	fields["GPSLongitude"] = longitude
+	fields["GPSLongitudeRef"] = longitude
+	if location.Accuracy > 0 {
+		fields["GPSHPositioningError"] = location.Accuracy
+	}
+
+	if location.Altitude != nil {
+		// like the latitude and longitude refs, exiftool picks above or below sea level from the sign
+		fields["GPSAltitude"] = math.Abs(*location.Altitude)
+		fields["GPSAltitudeRef"] = *location.Altitude
+	}
+	if location.Velocity != nil {
+		// velocity is in m/s, written in km/h
+		fields["GPSSpeed"] = *location.Velocity * 3.6
+		fields["GPSSpeedRef"] = "K"
+	}
+	if location.Heading != nil {
+		fields["GPSImgDirection"] = *location.Heading
+		fields["GPSImgDirectionRef"] = "T"
+	}
+
+	timestamp := location.Timestamp.UTC()
+	fields["GPSDateStamp"] = timestamp.Format("2006:01:02")
+	fields["GPSTimeStamp"] = timestamp.Format("15:04:05")
+}
+
+func setupExiftool(backup bool) (*exiftool.Exiftool, error) {
+	// coordinates are read as signed decimal degrees rather than degrees, minutes and seconds
+	exiftoolOpts := [](func(*exiftool.Exiftool) error){exiftool.CoordFormant("%+.8f")}
 	if *exiftoolBinary != "" {
 		exiftoolOpts = append(exiftoolOpts, exiftool.SetExiftoolBinaryPath(*exiftoolBinary))
 	}
-	if !*skipBackup {
+	// with an output directory only copies are modified and no backup is needed
+	if backup && !*skipBackup && *outputDir == "" {
 		exiftoolOpts = append(exiftoolOpts, exiftool.BackupOriginal())
 	}
 
@@ -200,27 +824,290 @@ func setupExiftool() (*exiftool.Exiftool, error) {
 	return et, nil
 }
 
-// reads locations from a Records.json google takeout file and returns a btree with all the locations
-func readLocations(locationFile string) (*btree.BTreeG[Location], error) {
-	bytes, err := os.ReadFile(locationFile)
-	if err != nil {
-		return nil, err
+// deletes the photos this run extracted from archives that weren't successfully written, so that the output
+// directory only has fixed copies. Nothing a previous run left in the output directory is deleted
+func removeFailedCopies(extractedCopies map[string]bool, written []exiftool.FileMetadata) {
+	fixed := map[string]bool{}
+	for _, v := range written {
+		if v.Err == nil {
+			fixed[v.File] = true
+		}
 	}
 
-	var locations LocationFile
-	err = json.Unmarshal(bytes, &locations)
-	if err != nil {
-		return nil, err
+	for path := range extractedCopies {
+		// a raw is fixed when its sidecar is written
+		if fixed[path] || fixed[xmpSidecarPath(path)] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			logrus.Warnf("Error when removing the unchanged copy %v: %v", path, err)
+		}
 	}
+}
 
+// reads locations from Records.json or Timeline.json google takeout files, or GPX, KML or GeoJSON tracks, and returns a btree with all the locations.
+// When given a directory, like the Semantic Location History one, every location file inside it is read into the same btree.
+// When given a Takeout archive, every location file in its Location History folder is.
+func readLocations(locationFiles ...string) (*btree.BTreeG[Location], error) {
 	btree := btree.NewG[Location](2, locationLessFunc)
-	for _, v := range locations.Locations {
-		btree.ReplaceOrInsert(v)
+
+	for _, locationFile := range locationFiles {
+		if isArchive(locationFile) {
+			err := walkArchive(locationFile, func(name string, size int64, modTime time.Time, reader io.Reader) error {
+				if !isLocationHistoryEntry(name) {
+					return nil
+				}
+				logrus.Debugf("Reading locations from %v in %v", name, locationFile)
+				return readLocationStream(btree, name, reader, size, locationFile)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", locationFile, err)
+			}
+			continue
+		}
+
+		stat, err := os.Stat(locationFile)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			if err := readLocationFile(btree, locationFile, locationFile); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = filepath.WalkDir(locationFile, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsDir() || !locationFileExtensions[strings.ToLower(filepath.Ext(d.Name()))] {
+				return nil
+			}
+			logrus.Debugf("Reading locations from %v", path)
+			return readLocationFile(btree, path, locationFile)
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return btree, nil
 }
 
+// reads all the locations of a single file into the btree, source being the --location-records it was found in.
+// The file is decoded as a stream so that multi-gigabyte exports don't have to fit in memory.
+func readLocationFile(btree *btree.BTreeG[Location], locationFile, source string) error {
+	file, err := os.Open(locationFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return readLocationStream(btree, locationFile, file, stat.Size(), source)
+}
+
+// reads all the locations from the reader into the btree, name is used to pick the format and in messages.
+// The locations are tagged with their source, the --location-records they were found in, and its priority
+func readLocationStream(btree *btree.BTreeG[Location], locationFile string, file io.Reader, size int64, source string) error {
+	priority := (*sourcePriority)[source]
+	progress := &progressReader{reader: file, total: size}
+	reader := bufio.NewReader(progress)
+
+	decode, err := locationFileDecoder(locationFile, reader)
+	if err != nil {
+		return err
+	}
+
+	err = decode(reader, func(l Location) {
+		if !isLocationAllowed(l) {
+			return
+		}
+		l.Priority, l.SourceFile = priority, source
+		insertLocation(btree, l)
+		if btree.Len()%progressReportInterval == 0 {
+			logrus.Infof("Read %v GPS locations so far (%.0f%% of %v)", btree.Len(), progress.percentage(), locationFile)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("%v: %w", locationFile, err)
+	}
+	return nil
+}
+
+// inserts a location in the btree unless a near-identical one from another source is already there, see --dedup-window
+// and --dedup-distance. When there's a duplicate, preferLocation decides which of the two is kept.
+func insertLocation(btree *btree.BTreeG[Location], l Location) {
+	if existing, found := btree.Get(l); found {
+		if !preferLocation(l, existing) {
+			return
+		}
+	} else if l.EndTimestamp.IsZero() && *dedupWindow > 0 {
+		var duplicate *Location
+		btree.AscendRange(Location{Timestamp: l.Timestamp.Add(-*dedupWindow - 1)}, Location{Timestamp: l.Timestamp.Add(*dedupWindow + 1)}, func(c Location) bool {
+			if c.EndTimestamp.IsZero() && c.SourceFile != l.SourceFile && distance(c, l) <= *dedupDistance {
+				duplicate = &c
+				return false
+			}
+			return true
+		})
+
+		if duplicate != nil {
+			if !preferLocation(l, *duplicate) {
+				return
+			}
+			logrus.Tracef("Replacing location %+v with the near-identical %+v", *duplicate, l)
+			btree.Delete(*duplicate)
+		}
+	}
+
+	btree.ReplaceOrInsert(l)
+	if interval := l.EndTimestamp.Sub(l.Timestamp); interval > longestInterval {
+		longestInterval = interval
+	}
+}
+
+// how many locations are read between each progress report
+const progressReportInterval = 500000
+
+// the extensions of the files read when walking a location directory
+var locationFileExtensions = map[string]bool{
+	".json":    true,
+	".geojson": true,
+	".gpx":     true,
+	".kml":     true,
+}
+
+// picks the decoder for a location file from its extension, or by sniffing its first character when the extension is unknown
+func locationFileDecoder(locationFile string, reader *bufio.Reader) (func(io.Reader, func(Location)) error, error) {
+	switch strings.ToLower(filepath.Ext(locationFile)) {
+	case ".json", ".geojson":
+		return decodeJSONLocations, nil
+	case ".gpx", ".kml":
+		return decodeXMLLocations, nil
+	}
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%v: unable to detect the format: %w", locationFile, err)
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n', 0xEF, 0xBB, 0xBF: // whitespace and the UTF-8 byte order mark
+			continue
+		case '{':
+			return decodeJSONLocations, reader.UnreadByte()
+		case '<':
+			return decodeXMLLocations, reader.UnreadByte()
+		}
+		return nil, fmt.Errorf("%v: unable to detect the format", locationFile)
+	}
+}
+
+func decodeJSONLocations(reader io.Reader, insert func(Location)) error {
+	return decodeLocations(json.NewDecoder(reader), insert)
+}
+
+// decoders for a single entry of each top level array we know how to read.
+// The keys present in the file determine its format:
+//   - locations: the Records.json from the Location History takeout
+//   - semanticSegments and rawSignals: the Timeline.json exported from the phone
+//   - timelineObjects: the monthly files from the Semantic Location History takeout
+//   - features: a GeoJSON feature collection
+var locationArrayDecoders = map[string]func(*json.Decoder, func(Location)) error{
+	"locations":        decodeRecordsLocation,
+	"semanticSegments": decodeTimelineSegment,
+	"rawSignals":       decodeTimelineRawSignal,
+	"timelineObjects":  decodeSemanticTimelineObject,
+	"features":         decodeGeoJSONFeature,
+}
+
+// decodes the top level object of a location file, calling insert for each location found in the known arrays
+func decodeLocations(decoder *json.Decoder, insert func(Location)) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		key, _ := token.(string)
+		decodeEntry, ok := locationArrayDecoders[key]
+		if !ok {
+			// skip the values of any keys we don't care about
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return err
+			}
+			continue
+		}
+
+		logrus.Debugf("Reading locations from the %v array", key)
+		if err := expectDelim(decoder, '['); err != nil {
+			return err
+		}
+		for decoder.More() {
+			if err := decodeEntry(decoder, insert); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(decoder, '}')
+}
+
+// decodes an entry of the locations array from Records.json
+func decodeRecordsLocation(decoder *json.Decoder, insert func(Location)) error {
+	var location Location
+	if err := decoder.Decode(&location); err != nil {
+		return err
+	}
+	insert(location)
+	return nil
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v but found %v at offset %v", delim, token, decoder.InputOffset())
+	}
+	return nil
+}
+
+// wraps a reader and keeps track of how much of it was read
+type progressReader struct {
+	reader io.Reader
+	read   int64
+	total  int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.read += int64(n)
+	return n, err
+}
+
+func (p *progressReader) percentage() float64 {
+	if p.total == 0 {
+		return 100
+	}
+	return float64(p.read) / float64(p.total) * 100
+}
+
 func getUnsignedDateDifference(a, b time.Time) time.Duration {
 	if a.Before(b) {
 		return b.Sub(a)
@@ -231,16 +1118,15 @@ func getUnsignedDateDifference(a, b time.Time) time.Duration {
 func findLocationFromDate(locations *btree.BTreeG[Location], dateToFindTime time.Time) *Location {
 	var closestMatch *Location
 	closestMatchDifference := 999999 * time.Hour
-	locations.AscendRange(Location{Timestamp: dateToFindTime.Add(-*tolerance)}, Location{Timestamp: dateToFindTime.Add(*tolerance)}, (func(l Location) bool {
-		currentDifference := getUnsignedDateDifference(l.Timestamp, dateToFindTime)
+	locations.AscendRange(Location{Timestamp: dateToFindTime.Add(-*tolerance - longestInterval)}, Location{Timestamp: dateToFindTime.Add(*tolerance)}, (func(l Location) bool {
+		currentDifference := l.timeDifference(dateToFindTime)
 
-		// Stop right away if the exact date is found
-		if l.Timestamp == dateToFindTime {
-			closestMatch = &l
+		// Stop once past the date and further than the best match, the remaining locations can only be worse
+		if l.Timestamp.After(dateToFindTime) && currentDifference > closestMatchDifference+*gpsPreferenceWindow {
 			return false
 		}
 
-		if currentDifference < closestMatchDifference {
+		if closestMatch == nil || isBetterMatch(l, currentDifference, *closestMatch, closestMatchDifference) {
 			closestMatch = &l
 			closestMatchDifference = currentDifference
 		}
@@ -248,6 +1134,13 @@ func findLocationFromDate(locations *btree.BTreeG[Location], dateToFindTime time
 		return true
 	}))
 
+	// a photo is only interpolated when it's within --tolerance of the closest location, like it would be matched without interpolating
+	if *interpolate != "" && closestMatch != nil && closestMatchDifference != 0 && closestMatchDifference <= *tolerance {
+		if interpolated := interpolateLocationFromDate(locations, dateToFindTime); interpolated != nil {
+			return interpolated
+		}
+	}
+
 	if closestMatch == nil || closestMatchDifference > *tolerance {
 		logrus.Tracef("No location found within the defined tolerance.")
 		return nil
@@ -255,6 +1148,48 @@ func findLocationFromDate(locations *btree.BTreeG[Location], dateToFindTime time
 	return closestMatch
 }
 
+// finds the points recorded just before and just after the date and interpolates between them, weighted by time.
+// Returns nil when there's no point on either side, or when the gap or the implied speed between them is implausible.
+func interpolateLocationFromDate(locations *btree.BTreeG[Location], dateToFindTime time.Time) *Location {
+	var before, after *Location
+	locations.DescendLessOrEqual(Location{Timestamp: dateToFindTime}, func(l Location) bool {
+		if l.EndTimestamp.IsZero() {
+			before = &l
+			return false
+		}
+		return true
+	})
+	locations.AscendGreaterOrEqual(Location{Timestamp: dateToFindTime}, func(l Location) bool {
+		if l.EndTimestamp.IsZero() {
+			after = &l
+			return false
+		}
+		return true
+	})
+
+	if before == nil || after == nil {
+		logrus.Tracef("Not interpolating, there's no location on both sides of %v", dateToFindTime)
+		return nil
+	}
+
+	gap := after.Timestamp.Sub(before.Timestamp)
+	if gap > *maxInterpolationGap {
+		logrus.Tracef("Not interpolating, the gap of %v between locations is over --max-interpolation-gap", gap)
+		return nil
+	}
+	if gap > 0 {
+		speed := distance(*before, *after) / gap.Hours() / 1000
+		if speed > *maxInterpolationSpeed {
+			logrus.Tracef("Not interpolating, the implied speed of %.0f km/h between locations is over --max-interpolation-speed", speed)
+			return nil
+		}
+	}
+
+	interpolated := interpolateLocation(*before, *after, dateToFindTime, *interpolate == "linear")
+	interpolated.InterpolationGap = min(dateToFindTime.Sub(before.Timestamp), after.Timestamp.Sub(dateToFindTime))
+	return &interpolated
+}
+
 func requestConfirmation() bool {
 	reader := bufio.NewReader(os.Stdin)
 	response, err := reader.ReadString('\n')
@@ -22,6 +22,11 @@ func TestReadLocations(t *testing.T) {
 		if locations.Len() != 5 {
 			t.Errorf("Expected 5 locations, got %d", locations.Len())
 		}
+
+		first, _ := locations.Min()
+		if first.Accuracy != 34 || first.Source != "WIFI" || first.DeviceTag != 112 {
+			t.Errorf("Expected accuracy, source and device tag to be read, got %+v", first)
+		}
 	})
 
 	// Test reading non-existent file
@@ -46,6 +51,81 @@ func TestReadLocations(t *testing.T) {
 			t.Error("Expected error reading invalid JSON, got nil")
 		}
 	})
+
+	// Test that keys other than locations are skipped while streaming
+	t.Run("UnknownKeys", func(t *testing.T) {
+		tmpFile := filepath.Join(t.TempDir(), "Records.json")
+		content := `{"other": {"nested": [1, 2, 3]}, "locations": [
+			{"latitudeE7": 395107349, "longitudeE7": -91427899, "timestamp": "2019-04-19T20:08:28.785Z"}
+		], "trailing": "value"}`
+		err := os.WriteFile(tmpFile, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+
+		locations, err := readLocations(tmpFile)
+		if err != nil {
+			t.Fatalf("Failed to read locations: %v", err)
+		}
+		if locations.Len() != 1 {
+			t.Errorf("Expected 1 location, got %d", locations.Len())
+		}
+	})
+
+	// Test merging several sources, with near-identical points de-duplicated by priority
+	t.Run("MultipleSources", func(t *testing.T) {
+		originalPriority := sourcePriority
+		defer func() { sourcePriority = originalPriority }()
+
+		dir := t.TempDir()
+		phone := filepath.Join(dir, "Records.json")
+		garmin := filepath.Join(dir, "track.gpx")
+		sourcePriority = &map[string]int{garmin: 10}
+
+		phoneContent := `{"locations": [
+			{"latitudeE7": 395107349, "longitudeE7": -91427899, "accuracy": 34, "timestamp": "2019-04-19T20:00:00Z"},
+			{"latitudeE7": 258135945, "longitudeE7": 81338558, "accuracy": 34, "timestamp": "2019-04-19T21:00:00Z"}
+		]}`
+		// the first point is 2 seconds and a few metres away from the phone one, the second is somewhere else entirely
+		garminContent := `<gpx><trk><trkseg>
+			<trkpt lat="39.5107400" lon="-9.1427900"><time>2019-04-19T20:00:02Z</time></trkpt>
+			<trkpt lat="40.0" lon="-8.0"><time>2019-04-19T21:00:00Z</time></trkpt>
+		</trkseg></trk></gpx>`
+		if err := os.WriteFile(phone, []byte(phoneContent), 0644); err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		if err := os.WriteFile(garmin, []byte(garminContent), 0644); err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+
+		locations, err := readLocations(phone, garmin)
+		if err != nil {
+			t.Fatalf("Failed to read locations: %v", err)
+		}
+
+		if locations.Len() != 3 {
+			t.Fatalf("Expected 3 locations, got %d", locations.Len())
+		}
+		first, _ := locations.Min()
+		if first.LatitudeE7 != 395107400 || first.Priority != 10 {
+			t.Errorf("Expected the duplicate from the higher priority source to be kept, got %+v", first)
+		}
+	})
+
+	// Test reading a file that is cut off in the middle of the locations array
+	t.Run("TruncatedFile", func(t *testing.T) {
+		tmpFile := filepath.Join(t.TempDir(), "Records.json")
+		content := `{"locations": [{"latitudeE7": 395107349, "longitudeE7": -91427899, "timestamp": "2019-04-19T20:08:28.785Z"}, {"latitudeE7": 3951`
+		err := os.WriteFile(tmpFile, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+
+		_, err = readLocations(tmpFile)
+		if err == nil {
+			t.Error("Expected error reading truncated file, got nil")
+		}
+	})
 }
 
 func TestGetUnsignedDateDifference(t *testing.T) {
@@ -181,6 +261,256 @@ func TestFindLocationFromDate(t *testing.T) {
 	}
 }
 
+func TestFindLocationFromDateInterpolated(t *testing.T) {
+	locations := btree.NewG[Location](2, locationLessFunc)
+	for _, loc := range []Location{
+		// 10km in 10 minutes, then a jump of over 1000km in 10 minutes and a gap of 5 hours
+		{LatitudeE7: 0, LongitudeE7: 0, Timestamp: time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC)},
+		{LatitudeE7: 0, LongitudeE7: 899322, Timestamp: time.Date(2019, 4, 19, 20, 10, 0, 0, time.UTC)},
+		{LatitudeE7: 100000000, LongitudeE7: 899322, Timestamp: time.Date(2019, 4, 19, 20, 20, 0, 0, time.UTC)},
+		{LatitudeE7: 100000000, LongitudeE7: 0, Timestamp: time.Date(2019, 4, 20, 1, 20, 0, 0, time.UTC)},
+	} {
+		locations.ReplaceOrInsert(loc)
+	}
+
+	// Save original flags
+	originalTolerance, originalInterpolate := tolerance, interpolate
+	defer func() { tolerance, interpolate = originalTolerance, originalInterpolate }()
+
+	testTolerance := 1 * time.Hour
+	tolerance = &testTolerance
+	testInterpolate := "great-circle"
+	interpolate = &testInterpolate
+
+	tests := []struct {
+		name        string
+		searchTime  time.Time
+		expectedLat int
+		expectedLon int
+	}{
+		{
+			name:        "Interpolated",
+			searchTime:  time.Date(2019, 4, 19, 20, 5, 0, 0, time.UTC),
+			expectedLat: 0,
+			expectedLon: 449661,
+		},
+		{
+			// falls back to the closest location
+			name:        "ImplausibleSpeed",
+			searchTime:  time.Date(2019, 4, 19, 20, 12, 0, 0, time.UTC),
+			expectedLat: 0,
+			expectedLon: 899322,
+		},
+		{
+			name:        "ImplausibleGap",
+			searchTime:  time.Date(2019, 4, 19, 20, 30, 0, 0, time.UTC),
+			expectedLat: 100000000,
+			expectedLon: 899322,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := findLocationFromDate(locations, tt.searchTime)
+			if result == nil {
+				t.Fatal("Expected to find location, got nil")
+			}
+			if result.LatitudeE7 != tt.expectedLat || result.LongitudeE7 != tt.expectedLon {
+				t.Errorf("Expected %d, %d, got %d, %d", tt.expectedLat, tt.expectedLon, result.LatitudeE7, result.LongitudeE7)
+			}
+		})
+	}
+}
+
+func TestFindLocationFromDateInterpolationTolerance(t *testing.T) {
+	locations := btree.NewG[Location](2, locationLessFunc)
+	// 1km in 50 minutes
+	locations.ReplaceOrInsert(Location{LatitudeE7: 0, LongitudeE7: 0, Timestamp: time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC)})
+	locations.ReplaceOrInsert(Location{LatitudeE7: 0, LongitudeE7: 89932, Timestamp: time.Date(2019, 4, 19, 20, 50, 0, 0, time.UTC)})
+
+	originalTolerance, originalInterpolate := tolerance, interpolate
+	defer func() { tolerance, interpolate = originalTolerance, originalInterpolate }()
+	testTolerance := 10 * time.Minute
+	tolerance = &testTolerance
+	testInterpolate := "linear"
+	interpolate = &testInterpolate
+
+	if result := findLocationFromDate(locations, time.Date(2019, 4, 19, 20, 25, 0, 0, time.UTC)); result != nil {
+		t.Errorf("Expected no location 25 minutes from both points with a 10 minute tolerance, got %+v", result)
+	}
+	if result := findLocationFromDate(locations, time.Date(2019, 4, 19, 20, 5, 0, 0, time.UTC)); result == nil || result.LongitudeE7 == 0 {
+		t.Errorf("Expected an interpolated location within the tolerance, got %+v", result)
+	}
+}
+
+func TestFindLocationFromDatePrefersGPS(t *testing.T) {
+	locations := btree.NewG[Location](2, locationLessFunc)
+	for _, loc := range []Location{
+		{LatitudeE7: 1, Source: "WIFI", Timestamp: time.Date(2019, 4, 19, 19, 59, 0, 0, time.UTC)},
+		{LatitudeE7: 2, Source: "GPS", Timestamp: time.Date(2019, 4, 19, 20, 1, 0, 0, time.UTC)},
+		{LatitudeE7: 3, Source: "CELL", Timestamp: time.Date(2019, 4, 19, 20, 5, 0, 0, time.UTC)},
+		{LatitudeE7: 4, Source: "GPS", Timestamp: time.Date(2019, 4, 19, 20, 8, 0, 0, time.UTC)},
+	} {
+		locations.ReplaceOrInsert(loc)
+	}
+
+	// Save original flags
+	originalTolerance, originalWindow := tolerance, gpsPreferenceWindow
+	defer func() { tolerance, gpsPreferenceWindow = originalTolerance, originalWindow }()
+
+	testTolerance := 1 * time.Hour
+	tolerance = &testTolerance
+
+	tests := []struct {
+		name        string
+		window      time.Duration
+		searchTime  time.Time
+		expectedLat int
+	}{
+		{
+			name:        "EquallyClose",
+			searchTime:  time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC),
+			expectedLat: 2,
+		},
+		{
+			name:        "CloserWithoutWindow",
+			searchTime:  time.Date(2019, 4, 19, 20, 6, 0, 0, time.UTC),
+			expectedLat: 3,
+		},
+		{
+			name:        "WithinWindow",
+			window:      2 * time.Minute,
+			searchTime:  time.Date(2019, 4, 19, 20, 6, 0, 0, time.UTC),
+			expectedLat: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := tt.window
+			gpsPreferenceWindow = &window
+
+			result := findLocationFromDate(locations, tt.searchTime)
+			if result == nil {
+				t.Fatal("Expected to find location, got nil")
+			}
+			if result.LatitudeE7 != tt.expectedLat {
+				t.Errorf("Expected latitude %d, got %d", tt.expectedLat, result.LatitudeE7)
+			}
+		})
+	}
+}
+
+func TestIsLocationAllowed(t *testing.T) {
+	// Save original flags
+	originalMaxAccuracy, originalDeviceTags := maxAccuracy, deviceTags
+	defer func() { maxAccuracy, deviceTags = originalMaxAccuracy, originalDeviceTags }()
+
+	testMaxAccuracy := 100
+	maxAccuracy = &testMaxAccuracy
+	testDeviceTags := []int{112}
+	deviceTags = &testDeviceTags
+
+	tests := []struct {
+		name     string
+		location Location
+		expected bool
+	}{
+		{"Accurate", Location{Accuracy: 34, DeviceTag: 112}, true},
+		{"Inaccurate", Location{Accuracy: 1500, DeviceTag: 112}, false},
+		{"UnknownAccuracy", Location{DeviceTag: 112}, true},
+		{"OtherDevice", Location{Accuracy: 34, DeviceTag: 987}, false},
+		{"UnknownDevice", Location{Accuracy: 34}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := isLocationAllowed(tt.location); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestPreferLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        Location
+		b        Location
+		expected bool
+	}{
+		{
+			name:     "HigherPriority",
+			a:        Location{Priority: 1, Accuracy: 100},
+			b:        Location{Priority: 0, Accuracy: 5},
+			expected: true,
+		},
+		{
+			name:     "LowerPriority",
+			a:        Location{Priority: 0, Accuracy: 5},
+			b:        Location{Priority: 1, Accuracy: 100},
+			expected: false,
+		},
+		{
+			name:     "BetterAccuracy",
+			a:        Location{Accuracy: 5},
+			b:        Location{Accuracy: 100},
+			expected: true,
+		},
+		{
+			name:     "UnknownAccuracy",
+			a:        Location{Accuracy: 0},
+			b:        Location{Accuracy: 100},
+			expected: false,
+		},
+		{
+			name:     "Tie",
+			a:        Location{Accuracy: 5},
+			b:        Location{Accuracy: 5},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := preferLocation(tt.a, tt.b)
+			if result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestInsertLocation(t *testing.T) {
+	timestamp := time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC)
+	first := Location{LatitudeE7: 395107349, LongitudeE7: -91427899, Timestamp: timestamp, Accuracy: 20, SourceFile: "Records.json"}
+	// 2 seconds and a few metres later
+	second := Location{LatitudeE7: 395107449, LongitudeE7: -91427899, Timestamp: timestamp.Add(2 * time.Second), Accuracy: 5, SourceFile: "Records.json"}
+
+	t.Run("SameSource", func(t *testing.T) {
+		locations := btree.NewG[Location](2, locationLessFunc)
+		insertLocation(locations, first)
+		insertLocation(locations, second)
+		if locations.Len() != 2 {
+			t.Errorf("Expected the points of a single source to all be kept, got %v", locations.Len())
+		}
+	})
+
+	t.Run("DifferentSources", func(t *testing.T) {
+		fromTrack := second
+		fromTrack.SourceFile = "track.gpx"
+		locations := btree.NewG[Location](2, locationLessFunc)
+		insertLocation(locations, first)
+		insertLocation(locations, fromTrack)
+		if locations.Len() != 1 {
+			t.Fatalf("Expected the near-identical points of different sources to be de-duplicated, got %v", locations.Len())
+		}
+		if kept, _ := locations.Min(); kept.SourceFile != "track.gpx" {
+			t.Errorf("Expected the more accurate point to be kept, got %+v", kept)
+		}
+	})
+}
+
 func TestLocationLessFunc(t *testing.T) {
 	loc1 := Location{
 		LatitudeE7:  258135945,
@@ -235,3 +565,50 @@ func TestLocationLessFunc(t *testing.T) {
 		})
 	}
 }
+
+func TestSetGPSFields(t *testing.T) {
+	altitude, velocity, heading := -12.5, 10.0, 270.0
+
+	t.Run("AllFields", func(t *testing.T) {
+		fields := map[string]interface{}{}
+		setGPSFields(fields, Location{
+			LatitudeE7:  395107349,
+			LongitudeE7: -91427899,
+			Timestamp:   time.Date(2019, 4, 19, 20, 8, 28, 785000000, time.UTC),
+			Accuracy:    34,
+			Altitude:    &altitude,
+			Velocity:    &velocity,
+			Heading:     &heading,
+		})
+
+		expected := map[string]interface{}{
+			"GPSLatitude":          float32(39.5107349),
+			"GPSLongitude":         float32(-9.1427899),
+			"GPSHPositioningError": 34,
+			"GPSAltitude":          12.5,
+			"GPSAltitudeRef":       -12.5,
+			"GPSSpeed":             36.0,
+			"GPSSpeedRef":          "K",
+			"GPSImgDirection":      270.0,
+			"GPSImgDirectionRef":   "T",
+			"GPSDateStamp":         "2019:04:19",
+			"GPSTimeStamp":         "20:08:28",
+		}
+		for field, value := range expected {
+			if fields[field] != value {
+				t.Errorf("Expected %v to be %v, got %v", field, value, fields[field])
+			}
+		}
+	})
+
+	t.Run("OnlyCoordinates", func(t *testing.T) {
+		fields := map[string]interface{}{}
+		setGPSFields(fields, Location{LatitudeE7: 395107349, LongitudeE7: -91427899})
+
+		for _, field := range []string{"GPSHPositioningError", "GPSAltitude", "GPSSpeed", "GPSImgDirection"} {
+			if _, ok := fields[field]; ok {
+				t.Errorf("Expected %v not to be set, got %v", field, fields[field])
+			}
+		}
+	})
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"os"
+	"strings"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+	"github.com/google/btree"
+	"github.com/sirupsen/logrus"
+)
+
+// With --map the photos about to be written are plotted on an HTML map before the confirmation prompt, for wrong
+// matches to stand out. The map works offline: there are no tiles, only a grid of latitudes and longitudes, and the
+// thumbnails embedded in the photos are embedded in the page.
+
+// the largest metadata read for the thumbnail of a photo. Every binary tag is read along with the thumbnail, and
+// the preview image of a photo alone can be several megabytes
+const thumbnailBufferSize = 64 * 1024 * 1024
+
+// the number of location history points kept around each photo
+const maxTrackPoints = 100
+
+type MapPhoto struct {
+	File             string    `json:"file"`
+	Latitude         float64   `json:"latitude"`
+	Longitude        float64   `json:"longitude"`
+	CaptureTime      time.Time `json:"captureTime"`
+	LocationTime     time.Time `json:"locationTime"`
+	TimeDeltaSeconds float64   `json:"timeDeltaSeconds"`
+	// zero when unknown
+	Accuracy   int    `json:"accuracy"`
+	Confidence string `json:"confidence"`
+	// data URL of the thumbnail embedded in the photo, empty when it has none
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// latitude and longitude of the location history points within --tolerance of the capture time
+	Track [][2]float64 `json:"track"`
+}
+
+// returns the photo taken at captureTime and matched to the location, with the location history around it
+func newMapPhoto(file string, location Location, captureTime time.Time, locations *btree.BTreeG[Location]) MapPhoto {
+	delta := location.timeDifference(captureTime)
+	return MapPhoto{
+		File:             file,
+		Latitude:         location.latitude(),
+		Longitude:        location.longitude(),
+		CaptureTime:      captureTime,
+		LocationTime:     location.Timestamp,
+		TimeDeltaSeconds: delta.Seconds(),
+		Accuracy:         location.Accuracy,
+		Confidence:       matchConfidence(delta, location.Accuracy),
+		Track:            trackAround(locations, captureTime),
+	}
+}
+
+// rates how likely the location is where the photo was taken, from how far in time and how accurate it is
+func matchConfidence(delta time.Duration, accuracy int) string {
+	switch {
+	case delta <= 5*time.Minute && accuracy <= 100:
+		return "high"
+	case delta <= 30*time.Minute && accuracy <= 1000:
+		return "medium"
+	}
+	return "low"
+}
+
+// returns the points of the location history within --tolerance of the time, evenly thinned out to maxTrackPoints
+func trackAround(locations *btree.BTreeG[Location], t time.Time) [][2]float64 {
+	points := []Location{}
+	locations.AscendRange(Location{Timestamp: t.Add(-*tolerance)}, Location{Timestamp: t.Add(*tolerance)}, func(l Location) bool {
+		points = append(points, l)
+		return true
+	})
+
+	track := [][2]float64{}
+	step := 1
+	if len(points) > maxTrackPoints {
+		step = (len(points) + maxTrackPoints - 1) / maxTrackPoints
+	}
+	for i := 0; i < len(points); i += step {
+		track = append(track, [2]float64{points[i].latitude(), points[i].longitude()})
+	}
+	return track
+}
+
+// sets the thumbnails of the photos to the ones embedded in them. Photos without one, like PNGs, are left without.
+func readThumbnails(photos []MapPhoto) error {
+	et, err := setupThumbnailExiftool()
+	if err != nil {
+		return err
+	}
+	defer func() { et.Close() }()
+
+	for i := range photos {
+		fileinfo := et.ExtractMetadata(photos[i].File)[0]
+		if fileinfo.Err != nil {
+			logrus.Debugf("No thumbnail for file %v: %v", fileinfo.File, fileinfo.Err)
+			// exiftool can't be read anymore once its output overflowed the buffer, it's started again for the next photos
+			if errors.Is(fileinfo.Err, exiftool.ErrBufferTooSmall) {
+				et.Close()
+				if et, err = setupThumbnailExiftool(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		photos[i].Thumbnail = thumbnailDataURL(fileinfo)
+	}
+	return nil
+}
+
+// starts the exiftool that reads the thumbnails, along with the other binary tags
+func setupThumbnailExiftool() (*exiftool.Exiftool, error) {
+	exiftoolOpts := [](func(*exiftool.Exiftool) error){
+		exiftool.ExtractAllBinaryMetadata(),
+		exiftool.Buffer(make([]byte, 128*1024), thumbnailBufferSize),
+	}
+	if *exiftoolBinary != "" {
+		exiftoolOpts = append(exiftoolOpts, exiftool.SetExiftoolBinaryPath(*exiftoolBinary))
+	}
+	return exiftool.NewExiftool(exiftoolOpts...)
+}
+
+// returns the embedded thumbnail as a data URL, exiftool gives binary fields as base64:<data>
+func thumbnailDataURL(fileinfo exiftool.FileMetadata) string {
+	for _, field := range []string{"ThumbnailImage", "PreviewImage"} {
+		value, err := fileinfo.GetString(field)
+		if err == nil && strings.HasPrefix(value, "base64:") {
+			return "data:image/jpeg;base64," + strings.TrimPrefix(value, "base64:")
+		}
+	}
+	return ""
+}
+
+// writes the HTML map of the photos
+func writeMap(path string, photos []MapPhoto) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := mapTemplate.Execute(file, map[string]interface{}{"Photos": photos}); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+var mapTemplate = template.Must(template.New("map").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Photos about to be geotagged</title>
+<style>
+body { margin: 0; display: flex; height: 100vh; font-family: sans-serif; font-size: 14px; }
+#map { flex: 1; background: #f4f6f8; cursor: grab; }
+#details { width: 320px; padding: 12px; overflow-y: auto; border-left: 1px solid #ccc; }
+#details img { max-width: 100%; }
+#details dt { font-weight: bold; margin-top: 6px; }
+#details dd { margin: 0; word-break: break-all; }
+.grid { stroke: #d8dde2; stroke-width: 1; }
+.label { fill: #8a949e; font-size: 11px; }
+.track { fill: none; stroke: #3b6fd8; stroke-width: 2; }
+.point { fill: #3b6fd8; }
+.high { fill: #2e9d46; }
+.medium { fill: #e5a11a; }
+.low { fill: #d63b3b; }
+.selected { stroke: #000; stroke-width: 2; }
+.legend span { display: inline-block; width: 10px; height: 10px; border-radius: 5px; margin: 0 4px 0 10px; }
+</style>
+</head>
+<body>
+<svg id="map"></svg>
+<div id="details">
+<p class="legend">Confidence:<span style="background:#2e9d46"></span>high<span style="background:#e5a11a"></span>medium<span style="background:#d63b3b"></span>low</p>
+<div id="photo">Click a photo to see it with the location history around it. Scroll to zoom and drag to move.</div>
+</div>
+<script>
+const photos = {{.Photos}};
+const svg = document.getElementById("map");
+const ns = "http://www.w3.org/2000/svg";
+
+// web mercator, x and y from 0 to 1
+function project(latitude, longitude) {
+  const sin = Math.sin(Math.max(-85, Math.min(85, latitude)) * Math.PI / 180);
+  return [(longitude + 180) / 360, 0.5 - Math.log((1 + sin) / (1 - sin)) / (4 * Math.PI)];
+}
+function unproject(x, y) {
+  const latitude = Math.atan(Math.sinh(Math.PI * (1 - 2 * y))) * 180 / Math.PI;
+  return [latitude, x * 360 - 180];
+}
+
+// the view is the projected point at the center and the pixels per projected unit
+let view = { x: 0.5, y: 0.5, scale: 512 };
+if (photos.length > 0) {
+  const xs = [], ys = [];
+  for (const photo of photos) {
+    const [x, y] = project(photo.latitude, photo.longitude);
+    xs.push(x); ys.push(y);
+  }
+  const minX = Math.min(...xs), maxX = Math.max(...xs), minY = Math.min(...ys), maxY = Math.max(...ys);
+  view.x = (minX + maxX) / 2;
+  view.y = (minY + maxY) / 2;
+  const span = Math.max(maxX - minX, maxY - minY, 0.0001);
+  view.scale = 0.8 * Math.min(svg.clientWidth, svg.clientHeight) / span;
+}
+let selected = null;
+
+function toScreen(latitude, longitude) {
+  const [x, y] = project(latitude, longitude);
+  return [(x - view.x) * view.scale + svg.clientWidth / 2, (y - view.y) * view.scale + svg.clientHeight / 2];
+}
+
+function element(name, attributes, parent) {
+  const e = document.createElementNS(ns, name);
+  for (const key in attributes) e.setAttribute(key, attributes[key]);
+  parent.appendChild(e);
+  return e;
+}
+
+// picks a grid step giving a few lines across the view
+function gridStep(degrees) {
+  for (const step of [30, 10, 5, 2, 1, 0.5, 0.2, 0.1, 0.05, 0.02, 0.01, 0.005, 0.002, 0.001]) {
+    if (degrees / step >= 4) return step;
+  }
+  return 0.0005;
+}
+
+function render() {
+  svg.textContent = "";
+  const width = svg.clientWidth, height = svg.clientHeight;
+  const [north, west] = unproject(view.x - width / 2 / view.scale, view.y - height / 2 / view.scale);
+  const [south, east] = unproject(view.x + width / 2 / view.scale, view.y + height / 2 / view.scale);
+  const step = gridStep(Math.min(east - west, north - south));
+  const decimals = Math.max(0, -Math.floor(Math.log10(step)));
+  for (let longitude = Math.ceil(west / step) * step; longitude <= east; longitude += step) {
+    const [x] = toScreen(0, longitude);
+    element("line", { x1: x, y1: 0, x2: x, y2: height, class: "grid" }, svg);
+    element("text", { x: x + 2, y: height - 4, class: "label" }, svg).textContent = longitude.toFixed(decimals) + "°";
+  }
+  for (let latitude = Math.ceil(Math.max(south, -85) / step) * step; latitude <= Math.min(north, 85); latitude += step) {
+    const [, y] = toScreen(latitude, 0);
+    element("line", { x1: 0, y1: y, x2: width, y2: y, class: "grid" }, svg);
+    element("text", { x: 2, y: y - 2, class: "label" }, svg).textContent = latitude.toFixed(decimals) + "°";
+  }
+
+  if (selected) {
+    const points = selected.track.map(p => toScreen(p[0], p[1]));
+    element("polyline", { points: points.map(p => p.join(",")).join(" "), class: "track" }, svg);
+    for (const [x, y] of points) element("circle", { cx: x, cy: y, r: 2, class: "point" }, svg);
+  }
+  photos.forEach((photo, i) => {
+    const [x, y] = toScreen(photo.latitude, photo.longitude);
+    if (x < -10 || y < -10 || x > width + 10 || y > height + 10) return;
+    const marker = element("circle", { cx: x, cy: y, r: 5, class: photo.confidence + (photo === selected ? " selected" : "") }, svg);
+    element("title", {}, marker).textContent = photo.file;
+    marker.addEventListener("click", event => { event.stopPropagation(); select(photo); });
+  });
+}
+
+function select(photo) {
+  selected = photo;
+  const details = document.getElementById("photo");
+  details.textContent = "";
+  if (photo.thumbnail) {
+    const img = document.createElement("img");
+    img.src = photo.thumbnail;
+    details.appendChild(img);
+  }
+  const list = document.createElement("dl");
+  const rows = [
+    ["File", photo.file],
+    ["Taken at", photo.captureTime],
+    ["Location recorded at", photo.locationTime],
+    ["Time delta", Math.round(photo.timeDeltaSeconds) + " s"],
+    ["Accuracy", photo.accuracy > 0 ? photo.accuracy + " m" : "unknown"],
+    ["Confidence", photo.confidence],
+    ["Coordinates", photo.latitude.toFixed(6) + ", " + photo.longitude.toFixed(6)],
+    ["Location history points around it", photo.track.length],
+  ];
+  for (const [name, value] of rows) {
+    list.appendChild(document.createElement("dt")).textContent = name;
+    list.appendChild(document.createElement("dd")).textContent = value;
+  }
+  details.appendChild(list);
+  render();
+}
+
+let drag = null;
+svg.addEventListener("mousedown", event => { drag = [event.clientX, event.clientY]; });
+window.addEventListener("mouseup", () => { drag = null; });
+window.addEventListener("mousemove", event => {
+  if (!drag) return;
+  view.x -= (event.clientX - drag[0]) / view.scale;
+  view.y -= (event.clientY - drag[1]) / view.scale;
+  drag = [event.clientX, event.clientY];
+  render();
+});
+svg.addEventListener("wheel", event => {
+  event.preventDefault();
+  const factor = event.deltaY < 0 ? 1.25 : 0.8;
+  const rect = svg.getBoundingClientRect();
+  // keep the point under the cursor in place
+  const dx = event.clientX - rect.left - rect.width / 2, dy = event.clientY - rect.top - rect.height / 2;
+  view.x += dx / view.scale - dx / (view.scale * factor);
+  view.y += dy / view.scale - dy / (view.scale * factor);
+  view.scale *= factor;
+  render();
+}, { passive: false });
+window.addEventListener("resize", render);
+render();
+</script>
+</body>
+</html>
+`))
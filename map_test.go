@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+	"github.com/google/btree"
+)
+
+func TestMatchConfidence(t *testing.T) {
+	tests := []struct {
+		delta    time.Duration
+		accuracy int
+		expected string
+	}{
+		{0, 0, "high"},
+		{5 * time.Minute, 100, "high"},
+		{2 * time.Minute, 500, "medium"},
+		{20 * time.Minute, 10, "medium"},
+		{45 * time.Minute, 10, "low"},
+		{time.Minute, 2000, "low"},
+	}
+
+	for _, tt := range tests {
+		if result := matchConfidence(tt.delta, tt.accuracy); result != tt.expected {
+			t.Errorf("Expected matchConfidence(%v, %v) to be %v, got %v", tt.delta, tt.accuracy, tt.expected, result)
+		}
+	}
+}
+
+func TestTrackAround(t *testing.T) {
+	originalTolerance := tolerance
+	defer func() { tolerance = originalTolerance }()
+	testTolerance := 10 * time.Minute
+	tolerance = &testTolerance
+
+	start := time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC)
+	locations := btree.NewG[Location](2, locationLessFunc)
+	// a point every second for an hour
+	for i := 0; i < 3600; i++ {
+		locations.ReplaceOrInsert(Location{LatitudeE7: 387000000 + i, LongitudeE7: -91000000, Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	track := trackAround(locations, start.Add(30*time.Minute))
+	if len(track) == 0 || len(track) > maxTrackPoints {
+		t.Fatalf("Expected between 1 and %v points, got %v", maxTrackPoints, len(track))
+	}
+	first := start.Add(20 * time.Minute)
+	if track[0][0] != float64(387000000+int(first.Sub(start).Seconds()))/1e7 || track[0][1] != -9.1 {
+		t.Errorf("Expected the track to start %v before the photo, got %v", testTolerance, track[0])
+	}
+
+	if track := trackAround(locations, start.Add(3*time.Hour)); len(track) != 0 {
+		t.Errorf("Expected no track away from the location history, got %v points", len(track))
+	}
+}
+
+func TestThumbnailDataURL(t *testing.T) {
+	fileinfo := exiftool.FileMetadata{Fields: map[string]interface{}{"ThumbnailImage": "base64:/9j/4AAQ", "PreviewImage": "base64:AAAA"}}
+	if result := thumbnailDataURL(fileinfo); result != "data:image/jpeg;base64,/9j/4AAQ" {
+		t.Errorf("Expected the thumbnail as a data URL, got %v", result)
+	}
+	fileinfo = exiftool.FileMetadata{Fields: map[string]interface{}{"PreviewImage": "base64:AAAA"}}
+	if result := thumbnailDataURL(fileinfo); result != "data:image/jpeg;base64,AAAA" {
+		t.Errorf("Expected the preview as a data URL, got %v", result)
+	}
+	fileinfo = exiftool.FileMetadata{Fields: map[string]interface{}{"ThumbnailImage": "(Binary data 6000 bytes, use -b option to extract)"}}
+	if result := thumbnailDataURL(fileinfo); result != "" {
+		t.Errorf("Expected no thumbnail without its binary data, got %v", result)
+	}
+}
+
+// a stand-in for exiftool that answers the stay_open protocol with the content of each file as its binary PreviewImage
+const fakeThumbnailExiftool = `#!/bin/sh
+file=""
+while IFS= read -r line; do
+	case "$line" in
+	False) exit 0 ;;
+	-execute)
+		printf '[{"SourceFile": "%s", "PreviewImage": "base64:%s"}]\n{ready}\n' "$file" "$(base64 < "$file" | tr -d '\n')"
+		file="" ;;
+	-*) ;;
+	*) file="$line" ;;
+	esac
+done
+`
+
+func TestReadThumbnails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake exiftool is a shell script")
+	}
+	tmpDir := t.TempDir()
+	binary := filepath.Join(tmpDir, "exiftool")
+	if err := os.WriteFile(binary, []byte(fakeThumbnailExiftool), 0755); err != nil {
+		t.Fatalf("Failed to create the fake exiftool: %v", err)
+	}
+	originalExiftoolBinary := exiftoolBinary
+	defer func() { exiftoolBinary = originalExiftoolBinary }()
+	exiftoolBinary = &binary
+
+	// a preview of a few hundred KB, over the 64 KB bufio.Scanner reads by default, followed by a small one
+	photos := []MapPhoto{{File: filepath.Join(tmpDir, "large.jpg")}, {File: filepath.Join(tmpDir, "small.jpg")}}
+	if err := os.WriteFile(photos[0].File, []byte(strings.Repeat("preview ", 50000)), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	if err := os.WriteFile(photos[1].File, []byte("thumbnail"), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+
+	if err := readThumbnails(photos); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(photos[0].Thumbnail) < 400000 {
+		t.Errorf("Expected the large preview to be read, got %v bytes", len(photos[0].Thumbnail))
+	}
+	if photos[1].Thumbnail != "data:image/jpeg;base64,dGh1bWJuYWls" {
+		t.Errorf("Expected the thumbnail of the photo after the large one to be read, got %q", photos[1].Thumbnail)
+	}
+}
+
+func TestNewMapPhotoInterpolated(t *testing.T) {
+	originalTolerance, originalInterpolate := tolerance, interpolate
+	defer func() { tolerance, interpolate = originalTolerance, originalInterpolate }()
+	testTolerance := time.Hour
+	tolerance = &testTolerance
+	testInterpolate := "linear"
+	interpolate = &testInterpolate
+
+	start := time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC)
+	locations := btree.NewG[Location](2, locationLessFunc)
+	locations.ReplaceOrInsert(Location{LatitudeE7: 387000000, LongitudeE7: -91000000, Timestamp: start, Accuracy: 10})
+	locations.ReplaceOrInsert(Location{LatitudeE7: 387100000, LongitudeE7: -91000000, Timestamp: start.Add(50 * time.Minute), Accuracy: 10})
+
+	// 20 minutes after the first point, 30 before the second
+	captureTime := start.Add(20 * time.Minute)
+	location := findLocationFromDate(locations, captureTime)
+	if location == nil {
+		t.Fatal("Expected an interpolated location")
+	}
+	photo := newMapPhoto("photo.jpg", *location, captureTime, locations)
+	if photo.TimeDeltaSeconds != 1200 || photo.Confidence != "medium" {
+		t.Errorf("Expected a 20 minute delta and medium confidence, got %v seconds and %v", photo.TimeDeltaSeconds, photo.Confidence)
+	}
+}
+
+func TestWriteMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.html")
+	photos := []MapPhoto{{
+		File:        "photos/<IMG_0001>.jpg",
+		Latitude:    38.7223,
+		Longitude:   -9.1393,
+		CaptureTime: time.Date(2019, 4, 19, 20, 1, 28, 0, time.UTC),
+		Confidence:  "high",
+		Thumbnail:   "data:image/jpeg;base64,/9j/4AAQ",
+		Track:       [][2]float64{{38.7220, -9.1390}, {38.7225, -9.1395}},
+	}}
+	if err := writeMap(path, photos); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the map: %v", err)
+	}
+	html := string(bytes)
+	for _, expected := range []string{`"latitude":38.7223`, `"confidence":"high"`, `[[38.722,-9.139],[38.7225,-9.1395]]`, `data:image/jpeg;base64,/9j/4AAQ`} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected the map to contain %v", expected)
+		}
+	}
+	if strings.Contains(html, "<IMG_0001>") {
+		t.Errorf("Expected the file name to be escaped in the map")
+	}
+	if strings.Contains(html, "https://") || strings.Contains(html, `src="http`) {
+		t.Errorf("Expected the map to load nothing from the network")
+	}
+}
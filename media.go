@@ -0,0 +1,137 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+// The formats the tool can fix. Each one declares where its capture time is read from and how the GPS tags are
+// written to it, exiftool doing the actual reading and writing.
+
+type MediaHandler struct {
+	Name       string
+	Extensions []string
+	// tags read, in order, by the DateTimeOriginal time source
+	DateTimeTags []string
+	// when set the date time tags without an offset are in UTC rather than in the camera's time zone
+	DateTimeUTC bool
+	// sets the metadata fields that write the location to a file of this format
+	SetGPSFields func(fields map[string]interface{}, location Location)
+	// when set the file is left untouched and the location is written to its XMP sidecar
+	XMPSidecar bool
+	// when set the file is a video, which can be placed at mid-clip with --video-mid-clip
+	Video bool
+}
+
+var mediaHandlers = []*MediaHandler{
+	{
+		Name:         "JPEG",
+		Extensions:   []string{".jpg", ".jpeg"},
+		DateTimeTags: []string{"DateTimeOriginal"},
+		SetGPSFields: setGPSFields,
+	},
+	{
+		Name:         "HEIF",
+		Extensions:   []string{".heic", ".heif", ".hif"},
+		DateTimeTags: []string{"DateTimeOriginal"},
+		SetGPSFields: setGPSFields,
+	},
+	{
+		Name:         "TIFF",
+		Extensions:   []string{".tif", ".tiff"},
+		DateTimeTags: []string{"DateTimeOriginal"},
+		SetGPSFields: setGPSFields,
+	},
+	{
+		Name:         "WebP",
+		Extensions:   []string{".webp"},
+		DateTimeTags: []string{"DateTimeOriginal"},
+		SetGPSFields: setGPSFields,
+	},
+	{
+		// PNGs keep exif in an eXIf chunk that few programs read, so the location is written as XMP.
+		// The capture time may be in either, or in the PNG CreationTime text chunk.
+		Name:         "PNG",
+		Extensions:   []string{".png"},
+		DateTimeTags: []string{"DateTimeOriginal", "DateCreated", "CreationTime"},
+		SetGPSFields: setXMPGPSFields,
+	},
+	{
+		Name:         "RAW",
+		Extensions:   []string{".cr2", ".cr3", ".nef", ".arw", ".orf", ".raf", ".dng"},
+		DateTimeTags: []string{"DateTimeOriginal"},
+		SetGPSFields: setXMPGPSFields,
+		XMPSidecar:   true,
+	},
+	{
+		// the Keys CreationDate written by phones has the offset, the QuickTime CreateDate is in UTC
+		Name:         "Video",
+		Extensions:   []string{".mp4", ".mov", ".m4v"},
+		DateTimeTags: []string{"CreationDate", "CreateDate"},
+		DateTimeUTC:  true,
+		SetGPSFields: setVideoGPSFields,
+		Video:        true,
+	},
+}
+
+// returns the metadata to write to set the location of the file, to the file itself or to its XMP sidecar.
+// Only the GPS tags are written: the other fields read from the file, like its Directory and FileName, would
+// otherwise be written back too, moving a copy in the output directory back to the photos directory.
+func (h *MediaHandler) prepareWrite(fileinfo exiftool.FileMetadata, location Location) exiftool.FileMetadata {
+	file := fileinfo.File
+	if h.usesXMPSidecar() {
+		file = xmpSidecarPath(fileinfo.File)
+	}
+	return exiftool.FileMetadata{File: file, Fields: h.gpsFields(location)}
+}
+
+// returns the fields that write the location, to the file or to its XMP sidecar
+func (h *MediaHandler) gpsFields(location Location) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if h.usesXMPSidecar() {
+		setXMPGPSFields(fields, location)
+	} else {
+		h.SetGPSFields(fields, location)
+	}
+	return fields
+}
+
+// reports whether the location of the files is written to an XMP sidecar, because of their format or --output
+func (h *MediaHandler) usesXMPSidecar() bool {
+	return h.XMPSidecar || *output == "sidecar"
+}
+
+// returns the handler of the files with the extension, nil if the format isn't supported
+func mediaHandlerForExtension(extension string) *MediaHandler {
+	extension = strings.ToLower(extension)
+	for _, handler := range mediaHandlers {
+		for _, handlerExtension := range handler.Extensions {
+			if extension == handlerExtension {
+				return handler
+			}
+		}
+	}
+	return nil
+}
+
+// returns the handler of the file, nil if its format isn't supported
+func mediaHandlerFor(path string) *MediaHandler {
+	return mediaHandlerForExtension(filepath.Ext(path))
+}
+
+// sets the fields that write the location to the XMP of the file. XMP has no refs for the coordinates,
+// their sign is enough, and keeps the GPS date and time in a single tag.
+func setXMPGPSFields(fields map[string]interface{}, location Location) {
+	exifFields := map[string]interface{}{}
+	setGPSFields(exifFields, location)
+	for _, tag := range []string{"GPSLatitudeRef", "GPSLongitudeRef", "GPSDateStamp", "GPSTimeStamp"} {
+		delete(exifFields, tag)
+	}
+
+	for tag, value := range exifFields {
+		fields["XMP:"+tag] = value
+	}
+	fields["XMP:GPSDateTime"] = location.Timestamp.UTC().Format(exifDateTimeLayout + "Z")
+}
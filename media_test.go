@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+func TestMediaHandlerFor(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"photos/IMG_0001.JPG", "JPEG"},
+		{"photos/IMG_0001.jpeg", "JPEG"},
+		{"photos/IMG_0001.HEIC", "HEIF"},
+		{"photos/scan.tiff", "TIFF"},
+		{"photos/sticker.webp", "WebP"},
+		{"photos/Screenshot.png", "PNG"},
+		{"photos/IMG_0001.CR2", "RAW"},
+		{"photos/IMG_0001.MOV", "Video"},
+		{"photos/notes.txt", ""},
+		{"photos/IMG_0001.jpg.json", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			handler := mediaHandlerFor(tt.path)
+			name := ""
+			if handler != nil {
+				name = handler.Name
+			}
+			if name != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, name)
+			}
+		})
+	}
+}
+
+func TestSetXMPGPSFields(t *testing.T) {
+	altitude := 45.5
+	fields := map[string]interface{}{}
+	mediaHandlerFor("Screenshot.png").SetGPSFields(fields, Location{
+		LatitudeE7:  395107349,
+		LongitudeE7: -91427899,
+		Timestamp:   time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+		Altitude:    &altitude,
+	})
+
+	expected := map[string]interface{}{
+		"XMP:GPSLatitude":    float32(39.5107349),
+		"XMP:GPSLongitude":   float32(-9.1427899),
+		"XMP:GPSAltitude":    45.5,
+		"XMP:GPSAltitudeRef": 45.5,
+		"XMP:GPSDateTime":    "2019:04:19 20:08:28Z",
+	}
+	if len(fields) != len(expected) {
+		t.Errorf("Expected %v fields, got %v", len(expected), fields)
+	}
+	for field, value := range expected {
+		if fields[field] != value {
+			t.Errorf("Expected %v to be %v, got %v", field, value, fields[field])
+		}
+	}
+}
+
+func TestReadCaptureTimeByFormat(t *testing.T) {
+	fields := map[string]interface{}{"CreationTime": "2019:04:19 20:08:28"}
+	expected := time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC)
+
+	result, _, source, err := readCaptureTime(exiftool.FileMetadata{File: "Screenshot.png", Fields: fields}, []string{"DateTimeOriginal"}, time.UTC)
+	if err != nil || !result.Equal(expected) || source != "DateTimeOriginal" {
+		t.Errorf("Expected %v from DateTimeOriginal, got %v from %v: %v", expected, result, source, err)
+	}
+
+	if _, _, _, err := readCaptureTime(exiftool.FileMetadata{File: "photo.jpg", Fields: fields}, []string{"DateTimeOriginal"}, time.UTC); err == nil {
+		t.Errorf("Expected the PNG CreationTime not to be read for a JPEG")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+	"github.com/google/btree"
+	"github.com/sirupsen/logrus"
+)
+
+// Camera clocks drift or are left on the wrong time zone while travelling. --camera-offset corrects the capture time
+// of every photo taken by a camera, and the estimate-offset command works out that correction from the photos that
+// already have GPS data, by finding the shift that brings them closest to the location history.
+
+// parses --camera-offset values in the Make/Model=+1h23m form into the offset to add to the capture time of each camera
+func parseCameraOffsets(values []string) (map[string]time.Duration, error) {
+	offsets := map[string]time.Duration{}
+	for _, value := range values {
+		separator := strings.LastIndex(value, "=")
+		if separator == -1 {
+			return nil, fmt.Errorf("invalid camera offset %q, expected Make/Model=offset", value)
+		}
+
+		offset, err := time.ParseDuration(strings.TrimSpace(value[separator+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid camera offset %q: %w", value, err)
+		}
+		offsets[strings.TrimSpace(value[:separator])] = offset
+	}
+	return offsets, nil
+}
+
+// returns the Make/Model the photo was taken with, as used by --camera-offset
+func cameraName(fileinfo exiftool.FileMetadata) string {
+	make, _ := fileinfo.GetString("Make")
+	model, _ := fileinfo.GetString("Model")
+	return strings.TrimSpace(make) + "/" + strings.TrimSpace(model)
+}
+
+// reads the coordinates of the photo's GPS tags, signed according to their Ref tags, or the GPSCoordinates of a video
+func readGPSCoordinates(fileinfo exiftool.FileMetadata) (float64, float64, bool) {
+	latitude, err := fileinfo.GetFloat("GPSLatitude")
+	if err != nil {
+		if coordinates, err := fileinfo.GetString("GPSCoordinates"); err == nil {
+			return parseGPSCoordinates(coordinates)
+		}
+		return 0, 0, false
+	}
+	longitude, err := fileinfo.GetFloat("GPSLongitude")
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if ref, _ := fileinfo.GetString("GPSLatitudeRef"); strings.HasPrefix(strings.ToUpper(ref), "S") {
+		latitude = -math.Abs(latitude)
+	}
+	if ref, _ := fileinfo.GetString("GPSLongitudeRef"); strings.HasPrefix(strings.ToUpper(ref), "W") {
+		longitude = -math.Abs(longitude)
+	}
+	return latitude, longitude, true
+}
+
+// parses the GPSCoordinates of a video, which exiftool prints as signed degrees like "+38.72230000, -9.13930000, 12 m",
+// or with the hemisphere like "38.7223 N, 9.1393 W". The altitude, when there's one, is ignored.
+func parseGPSCoordinates(value string) (float64, float64, bool) {
+	coordinates := []float64{}
+	for _, token := range strings.Fields(strings.ReplaceAll(value, ",", " ")) {
+		switch strings.ToUpper(token) {
+		case "N", "E":
+			continue
+		case "S", "W":
+			if len(coordinates) > 0 {
+				coordinates[len(coordinates)-1] = -math.Abs(coordinates[len(coordinates)-1])
+			}
+			continue
+		}
+		if len(coordinates) == 2 {
+			break
+		}
+		coordinate, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		coordinates = append(coordinates, coordinate)
+	}
+	if len(coordinates) < 2 {
+		return 0, 0, false
+	}
+	return coordinates[0], coordinates[1], true
+}
+
+// a photo with GPS data used to estimate the offset of its camera
+type offsetSample struct {
+	captureTime time.Time
+	// whether the capture time has an offset, without one it's resolved in the time zone of the location like when fixing
+	hasOffset bool
+	position  Location
+}
+
+type OffsetEstimate struct {
+	Camera string
+	Offset time.Duration
+	// share of the camera's samples that are within --offset-match-distance of the location history with the offset applied
+	Confidence float64
+	Matched    int
+	Samples    int
+	// median distance in metres between the samples and the location history with the offset applied
+	MedianDistance float64
+}
+
+// estimates the clock offset of each camera from its photos with GPS data, trying every --offset-step between
+// -max and +max and keeping the offset that puts the most photos within --offset-match-distance of the
+// location history. Ties are broken by the smaller median distance, then by how close in time the locations found are.
+// The locations are found the same way as when fixing the photos with --camera-offset, so the offset can be used as it is.
+func estimateOffsets(locations *btree.BTreeG[Location], samples map[string][]offsetSample, max, step time.Duration, boundaries *TimezoneBoundaries) []OffsetEstimate {
+	estimates := []OffsetEstimate{}
+	for camera, cameraSamples := range samples {
+		best := OffsetEstimate{Camera: camera, Samples: len(cameraSamples), MedianDistance: math.Inf(1)}
+		bestTimeDifference := time.Duration(math.MaxInt64)
+
+		for offset := -max; offset <= max; offset += step {
+			distances := []float64{}
+			matched := 0
+			// a range of offsets often finds the same locations, the best one puts the photos closest in time to them
+			var timeDifference time.Duration
+			for _, sample := range cameraSamples {
+				captureTime := sample.captureTime.Add(offset)
+				var location *Location
+				if sample.hasOffset {
+					location = findLocationFromDate(locations, captureTime)
+				} else {
+					location, captureTime = findLocationFromLocalTime(locations, captureTime, boundaries)
+				}
+				if location == nil {
+					continue
+				}
+				timeDifference += location.timeDifference(captureTime)
+				d := distance(*location, sample.position)
+				distances = append(distances, d)
+				if d <= *offsetMatchDistance {
+					matched++
+				}
+			}
+			if len(distances) == 0 {
+				continue
+			}
+
+			median := medianOf(distances)
+			if matched > best.Matched || (matched == best.Matched && (median < best.MedianDistance ||
+				(median == best.MedianDistance && timeDifference < bestTimeDifference))) {
+				best.Offset, best.Matched, best.MedianDistance = offset, matched, median
+				bestTimeDifference = timeDifference
+			}
+		}
+
+		best.Confidence = float64(best.Matched) / float64(best.Samples)
+		estimates = append(estimates, best)
+	}
+
+	sort.Slice(estimates, func(i, j int) bool { return estimates[i].Camera < estimates[j].Camera })
+	return estimates
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// the estimate-offset command, reports the estimated clock offset of each camera
+func runEstimateOffset(et *exiftool.Exiftool, locations *btree.BTreeG[Location], filesToProcess []string, archivePhotos ArchivePhotos, timezone *time.Location, timezoneBoundaries *TimezoneBoundaries) {
+	logrus.Infof("Reading the photos that already have GPS metadata")
+
+	samples := map[string][]offsetSample{}
+	for _, fileinfo := range archivePhotos.extractMetadata(et, filesToProcess...) {
+		if fileinfo.Err != nil {
+			logrus.Warnf("Skipping file %v because of an error when extracting metadata: %v", fileinfo.File, fileinfo.Err)
+			continue
+		}
+
+		latitude, longitude, ok := readGPSCoordinates(fileinfo)
+		if !ok {
+			continue
+		}
+		captureTime, hasOffset, _, err := readCaptureTime(fileinfo, fileTimeSources(fileinfo.File, archivePhotos), timezone)
+		if err != nil {
+			continue
+		}
+
+		camera := cameraName(fileinfo)
+		samples[camera] = append(samples[camera], offsetSample{
+			captureTime: captureTime,
+			hasOffset:   hasOffset,
+			position:    locationFromDegrees(latitude, longitude, captureTime),
+		})
+	}
+
+	if len(samples) == 0 {
+		logrus.Infof("No photos with GPS metadata and a capture time were found, the offset can't be estimated")
+		return
+	}
+
+	for _, estimate := range estimateOffsets(locations, samples, *maxOffset, *offsetStep, timezoneBoundaries) {
+		if estimate.Matched == 0 {
+			logrus.Infof("%v: no offset brings any of the %v photos within %vm of the location history", estimate.Camera, estimate.Samples, *offsetMatchDistance)
+			continue
+		}
+		logrus.Infof("%v: estimated offset %v with %.0f%% confidence (%v of %v photos within %vm, median distance %.0fm)",
+			estimate.Camera, estimate.Offset, estimate.Confidence*100, estimate.Matched, estimate.Samples, *offsetMatchDistance, estimate.MedianDistance)
+		logrus.Infof("\tuse it with --camera-offset \"%v=%v\"", estimate.Camera, estimate.Offset)
+	}
+}
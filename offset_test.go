@@ -0,0 +1,201 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+	"github.com/google/btree"
+)
+
+func TestParseCameraOffsets(t *testing.T) {
+	tests := []struct {
+		name        string
+		values      []string
+		expected    map[string]time.Duration
+		expectError bool
+	}{
+		{
+			name:     "Positive",
+			values:   []string{"Canon/Canon EOS 80D=+1h23m"},
+			expected: map[string]time.Duration{"Canon/Canon EOS 80D": time.Hour + 23*time.Minute},
+		},
+		{
+			name:     "NegativeAndSeveral",
+			values:   []string{"Canon/Canon EOS 80D=-30s", "SONY/ILCE-7M3 = 2h"},
+			expected: map[string]time.Duration{"Canon/Canon EOS 80D": -30 * time.Second, "SONY/ILCE-7M3": 2 * time.Hour},
+		},
+		{
+			name:        "MissingOffset",
+			values:      []string{"Canon/Canon EOS 80D"},
+			expectError: true,
+		},
+		{
+			name:        "InvalidOffset",
+			values:      []string{"Canon/Canon EOS 80D=1 hour"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseCameraOffsets(tt.values)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, result)
+			}
+			for camera, offset := range tt.expected {
+				if result[camera] != offset {
+					t.Errorf("Expected offset %v for %v, got %v", offset, camera, result[camera])
+				}
+			}
+		})
+	}
+}
+
+func TestReadGPSCoordinates(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    map[string]interface{}
+		latitude  float64
+		longitude float64
+		ok        bool
+	}{
+		{
+			name:      "Signed",
+			fields:    map[string]interface{}{"GPSLatitude": -33.8688, "GPSLongitude": 151.2093},
+			latitude:  -33.8688,
+			longitude: 151.2093,
+			ok:        true,
+		},
+		{
+			name:      "Refs",
+			fields:    map[string]interface{}{"GPSLatitude": "38.7223", "GPSLatitudeRef": "North", "GPSLongitude": "9.1393", "GPSLongitudeRef": "West"},
+			latitude:  38.7223,
+			longitude: -9.1393,
+			ok:        true,
+		},
+		{
+			name:      "VideoCoordinates",
+			fields:    map[string]interface{}{"GPSCoordinates": "+38.72230000, -9.13930000, 12 m"},
+			latitude:  38.7223,
+			longitude: -9.1393,
+			ok:        true,
+		},
+		{
+			name:      "VideoCoordinatesWithHemispheres",
+			fields:    map[string]interface{}{"GPSCoordinates": "38.7223 N, 9.1393 W"},
+			latitude:  38.7223,
+			longitude: -9.1393,
+			ok:        true,
+		},
+		{
+			name:   "Missing",
+			fields: map[string]interface{}{"DateTimeOriginal": "2019:04:19 21:08:28"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latitude, longitude, ok := readGPSCoordinates(exiftool.FileMetadata{Fields: tt.fields})
+			if ok != tt.ok || latitude != tt.latitude || longitude != tt.longitude {
+				t.Errorf("Expected %v, %v, %v, got %v, %v, %v", tt.latitude, tt.longitude, tt.ok, latitude, longitude, ok)
+			}
+		})
+	}
+}
+
+func TestEstimateOffsets(t *testing.T) {
+	// a walk through Lisbon, one point every 10 minutes, 100m apart
+	start := time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	locations := btree.NewG[Location](2, locationLessFunc)
+	for i := 0; i < 12; i++ {
+		locations.ReplaceOrInsert(Location{LatitudeE7: 387000000 + i*9000, LongitudeE7: -91000000, Timestamp: start.Add(time.Duration(i) * 10 * time.Minute)})
+	}
+
+	// the camera clock is 30 minutes behind, so the photos were really taken 30 minutes after the time they record
+	samples := map[string][]offsetSample{}
+	for _, i := range []int{4, 6, 8} {
+		position := Location{LatitudeE7: 387000000 + i*9000, LongitudeE7: -91000000}
+		samples["Canon/Canon EOS 80D"] = append(samples["Canon/Canon EOS 80D"], offsetSample{
+			captureTime: start.Add(time.Duration(i)*10*time.Minute - 30*time.Minute),
+			position:    position,
+		})
+	}
+
+	// Save original values
+	originalTolerance, originalMatchDistance := tolerance, offsetMatchDistance
+	defer func() { tolerance, offsetMatchDistance = originalTolerance, originalMatchDistance }()
+
+	testTolerance := 5 * time.Minute
+	testMatchDistance := 50.0
+	tolerance, offsetMatchDistance = &testTolerance, &testMatchDistance
+
+	estimates := estimateOffsets(locations, samples, time.Hour, time.Minute, nil)
+	if len(estimates) != 1 {
+		t.Fatalf("Expected 1 estimate, got %v", len(estimates))
+	}
+
+	estimate := estimates[0]
+	if estimate.Offset != 30*time.Minute {
+		t.Errorf("Expected offset 30m, got %v", estimate.Offset)
+	}
+	if estimate.Matched != 3 || estimate.Confidence != 1 {
+		t.Errorf("Expected all 3 photos to match, got %v with confidence %v", estimate.Matched, estimate.Confidence)
+	}
+	if estimate.MedianDistance != 0 {
+		t.Errorf("Expected median distance 0, got %v", estimate.MedianDistance)
+	}
+}
+
+func TestEstimateOffsetsLocalTime(t *testing.T) {
+	// a walk through Madrid for 6 hours, one point every 10 minutes, 100m apart
+	start := time.Date(2019, 7, 1, 8, 0, 0, 0, time.UTC)
+	locations := btree.NewG[Location](2, locationLessFunc)
+	for i := 0; i < 36; i++ {
+		locations.ReplaceOrInsert(Location{LatitudeE7: 404168000 + i*9000, LongitudeE7: -37038000, Timestamp: start.Add(time.Duration(i) * 10 * time.Minute)})
+	}
+
+	// the camera clock is right, on Madrid time (UTC+2 in July), and the photos have no offset so they're read in UTC
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+	samples := map[string][]offsetSample{}
+	for _, i := range []int{16, 18, 20} {
+		position := Location{LatitudeE7: 404168000 + i*9000, LongitudeE7: -37038000}
+		samples["Canon/Canon EOS 80D"] = append(samples["Canon/Canon EOS 80D"], offsetSample{
+			captureTime: wallClockIn(start.Add(time.Duration(i)*10*time.Minute).In(madrid), time.UTC),
+			position:    position,
+		})
+	}
+
+	originalTolerance, originalMatchDistance := tolerance, offsetMatchDistance
+	defer func() { tolerance, offsetMatchDistance = originalTolerance, originalMatchDistance }()
+	testTolerance := 5 * time.Minute
+	testMatchDistance := 50.0
+	tolerance, offsetMatchDistance = &testTolerance, &testMatchDistance
+
+	// the photos are fixed in the time zone of their location, so the camera needs no offset
+	estimates := estimateOffsets(locations, samples, 3*time.Hour, time.Minute, readTestTimezoneBoundaries(t))
+	if len(estimates) != 1 {
+		t.Fatalf("Expected 1 estimate, got %v", len(estimates))
+	}
+	if estimates[0].Offset != 0 || estimates[0].Matched != 3 {
+		t.Errorf("Expected offset 0 with all 3 photos matching, got %v with %v", estimates[0].Offset, estimates[0].Matched)
+	}
+
+	// without the time zone boundaries they're fixed in UTC, 2 hours after they were taken
+	estimates = estimateOffsets(locations, samples, 3*time.Hour, time.Minute, nil)
+	if estimates[0].Offset != -2*time.Hour {
+		t.Errorf("Expected offset -2h without time zone boundaries, got %v", estimates[0].Offset)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+// With --output-dir the photos directories are left untouched. The photos are still read where they are, and the
+// ones that get a location are copied to the same relative path below the output directory before being written.
+// The copies left in the output directory by previous runs are never overwritten.
+
+// the directory the photos in archives would be in if the archives were extracted there, set when there are archives
+// in the photos directories. Their paths inside the archives are mirrored in the output directory like a photos directory
+var archiveDirectory string
+
+// returns where the file below one of the photos directories is mirrored in the output directory
+func mirroredPath(path string) (string, bool) {
+	if *outputDir == "" {
+		return "", false
+	}
+	directories := []string{}
+	if archiveDirectory != "" {
+		directories = append(directories, archiveDirectory)
+	}
+	for _, photosDirectory := range *photosDirectories {
+		if !isArchive(photosDirectory) {
+			directories = append(directories, photosDirectory)
+		}
+	}
+	for _, photosDirectory := range directories {
+		relative, err := filepath.Rel(photosDirectory, path)
+		if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return filepath.Join(*outputDir, relative), true
+	}
+	return "", false
+}
+
+// the paths in the output directory claimed by the files of the photos directories, with the file that claimed each
+type mirroredTargets map[string]string
+
+// claims the path the file is mirrored to in the output directory. Fails when another of the photos directories has
+// a file at the same relative path, which would otherwise be written to the same copy.
+func (targets mirroredTargets) claim(path string) bool {
+	target, ok := mirroredPath(path)
+	if !ok {
+		return true
+	}
+	if other, ok := targets[target]; ok && other != path {
+		return false
+	}
+	targets[target] = path
+	return true
+}
+
+// copies the files about to be written to the output directory and points them to their copies.
+// XMP sidecars that don't exist yet aren't copied, they're created in the output directory. A copy that is already
+// there, the same as the file as checked by hasPreviousOutput, or extracted from an archive, is written as it is.
+func mirrorFilesToWrite(files []exiftool.FileMetadata) error {
+	for i, file := range files {
+		target, ok := mirroredPath(file.File)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(target); err == nil {
+			files[i].File = target
+			continue
+		}
+		if _, err := os.Stat(file.File); err == nil {
+			if err := copyFile(file.File, target); err != nil {
+				return err
+			}
+		} else if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		files[i].File = target
+	}
+	return nil
+}
+
+// reports whether the output directory has a copy of the file that differs from it, left by a previous run, which
+// can't be written without losing it. hash is the hash of the file when it isn't on disk, like a photo in an archive.
+func hasPreviousOutput(path, hash string) bool {
+	target, ok := mirroredPath(path)
+	if !ok {
+		return false
+	}
+	if _, err := os.Stat(target); err != nil {
+		return false
+	}
+	if hash == "" {
+		var err error
+		// a file that doesn't exist, like an XMP sidecar a previous run created in the output directory, differs from its copy
+		if hash, err = fileHash(path); err != nil {
+			return true
+		}
+	}
+	targetHash, err := fileHash(target)
+	return err != nil || targetHash != hash
+}
+
+// links or copies the photos that weren't written to the output directory, depending on --copy-unchanged
+func mirrorUnchangedFiles(files []string, written map[string]bool) error {
+	if *copyUnchanged == "none" {
+		return nil
+	}
+	for _, file := range files {
+		target, ok := mirroredPath(file)
+		if !ok || written[file] {
+			continue
+		}
+		if _, err := os.Stat(target); err == nil {
+			// left from a previous run
+			continue
+		}
+		if *copyUnchanged == "hardlink" {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Link(file, target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copyFile(file, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copies the file with its permissions and modification time, creating the target directory
+func copyFile(source, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("%v: %w", target, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
+
+// reports whether both paths are the same file or directory
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
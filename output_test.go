@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+func setupTestOutputDir(t *testing.T, copyMode string) (string, string) {
+	photosDir, outDir := t.TempDir(), t.TempDir()
+
+	originalPhotosDirectories, originalOutputDir, originalCopyUnchanged := photosDirectories, outputDir, copyUnchanged
+	t.Cleanup(func() {
+		photosDirectories, outputDir, copyUnchanged = originalPhotosDirectories, originalOutputDir, originalCopyUnchanged
+	})
+	directories := []string{"takeout.zip", photosDir}
+	photosDirectories, outputDir, copyUnchanged = &directories, &outDir, &copyMode
+
+	for _, name := range []string{"2019/photo.jpg", "2019/other.jpg", "2019/IMG_0001.CR2"} {
+		path := filepath.Join(photosDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create temp directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+	}
+	return photosDir, outDir
+}
+
+func TestMirroredPath(t *testing.T) {
+	photosDir, outDir := setupTestOutputDir(t, "none")
+
+	if result, ok := mirroredPath(filepath.Join(photosDir, "2019", "photo.jpg")); !ok || result != filepath.Join(outDir, "2019", "photo.jpg") {
+		t.Errorf("Expected the photo to be mirrored to %v, got %v", filepath.Join(outDir, "2019", "photo.jpg"), result)
+	}
+	if result, ok := mirroredPath(filepath.Join(outDir, "Takeout", "photo.jpg")); ok {
+		t.Errorf("Expected the photo extracted from an archive not to be mirrored, got %v", result)
+	}
+}
+
+func TestMirrorFiles(t *testing.T) {
+	photosDir, outDir := setupTestOutputDir(t, "copy")
+
+	photo := filepath.Join(photosDir, "2019", "photo.jpg")
+	sidecar := filepath.Join(photosDir, "2019", "IMG_0001.xmp")
+	files := []exiftool.FileMetadata{{File: photo}, {File: sidecar}}
+	written := map[string]bool{photo: true, sidecar: true}
+
+	if err := mirrorFilesToWrite(files); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if files[0].File != filepath.Join(outDir, "2019", "photo.jpg") || files[1].File != filepath.Join(outDir, "2019", "IMG_0001.xmp") {
+		t.Errorf("Expected the files to write to point to the output directory, got %v and %v", files[0].File, files[1].File)
+	}
+	if content, err := os.ReadFile(files[0].File); err != nil || string(content) != "2019/photo.jpg" {
+		t.Errorf("Expected the photo to be copied, got %q: %v", content, err)
+	}
+	if _, err := os.Stat(files[1].File); !os.IsNotExist(err) {
+		t.Errorf("Expected the missing sidecar not to be created")
+	}
+
+	allFiles := []string{photo, filepath.Join(photosDir, "2019", "other.jpg"), filepath.Join(photosDir, "2019", "IMG_0001.CR2")}
+	if err := mirrorUnchangedFiles(allFiles, written); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, name := range []string{"other.jpg", "IMG_0001.CR2"} {
+		if _, err := os.Stat(filepath.Join(outDir, "2019", name)); err != nil {
+			t.Errorf("Expected the unchanged %v to be copied: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(photosDir, "2019", "IMG_0001.xmp")); !os.IsNotExist(err) {
+		t.Errorf("Expected the photos directory to be left untouched")
+	}
+}
+
+func TestMirrorFilesToWriteFields(t *testing.T) {
+	photosDir, outDir := setupTestOutputDir(t, "none")
+
+	photo := filepath.Join(photosDir, "2019", "photo.jpg")
+	fileinfo := exiftool.FileMetadata{File: photo, Fields: map[string]interface{}{
+		"SourceFile": photo,
+		"Directory":  filepath.Join(photosDir, "2019"),
+		"FileName":   "photo.jpg",
+		"Make":       "Canon",
+	}}
+	files := []exiftool.FileMetadata{mediaHandlerFor(photo).prepareWrite(fileinfo, Location{LatitudeE7: 395107349, LongitudeE7: -91427901})}
+	if err := mirrorFilesToWrite(files); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if files[0].File != filepath.Join(outDir, "2019", "photo.jpg") {
+		t.Errorf("Expected the copy to be written, got %v", files[0].File)
+	}
+	for field := range files[0].Fields {
+		if !strings.HasPrefix(field, "GPS") {
+			t.Errorf("Expected only GPS fields to be written to the copy, got %v", field)
+		}
+	}
+	if files[0].Fields["GPSLatitude"] != float32(39.5107349) {
+		t.Errorf("Expected the latitude to be written to the copy, got %v", files[0].Fields)
+	}
+}
+
+func TestMirrorUnchangedFilesHardlink(t *testing.T) {
+	photosDir, outDir := setupTestOutputDir(t, "hardlink")
+
+	other := filepath.Join(photosDir, "2019", "other.jpg")
+	if err := mirrorUnchangedFiles([]string{other}, map[string]bool{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !sameFile(other, filepath.Join(outDir, "2019", "other.jpg")) {
+		t.Errorf("Expected the unchanged photo to be hard linked")
+	}
+}
+
+func TestHasPreviousOutput(t *testing.T) {
+	photosDir, outDir := setupTestOutputDir(t, "none")
+
+	photo := filepath.Join(photosDir, "2019", "photo.jpg")
+	if hasPreviousOutput(photo, "") {
+		t.Errorf("Expected no previous output without a copy")
+	}
+	if err := copyFile(photo, filepath.Join(outDir, "2019", "photo.jpg")); err != nil {
+		t.Fatalf("Failed to copy file: %v", err)
+	}
+	if hasPreviousOutput(photo, "") {
+		t.Errorf("Expected an identical copy to be written over")
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "2019", "photo.jpg"), []byte("fixed by a previous run"), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	if !hasPreviousOutput(photo, "") {
+		t.Errorf("Expected a different copy not to be written over")
+	}
+
+	// a sidecar created by a previous run, which doesn't exist in the photos directory
+	if err := os.WriteFile(filepath.Join(outDir, "2019", "IMG_0001.xmp"), []byte(emptyXMPSidecar), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	if !hasPreviousOutput(filepath.Join(photosDir, "2019", "IMG_0001.xmp"), "") {
+		t.Errorf("Expected the sidecar of a previous run not to be written over")
+	}
+}
+
+func TestMirroredTargetsClaim(t *testing.T) {
+	photosDir, _ := setupTestOutputDir(t, "none")
+	otherDir := t.TempDir()
+	directories := append(*photosDirectories, otherDir)
+	photosDirectories = &directories
+
+	targets := mirroredTargets{}
+	photo := filepath.Join(photosDir, "2019", "photo.jpg")
+	if !targets.claim(photo) {
+		t.Fatalf("Expected the first photo to claim its path in the output directory")
+	}
+	if !targets.claim(photo) {
+		t.Errorf("Expected the same photo to claim its path again, like when the photos directories overlap")
+	}
+	if targets.claim(filepath.Join(otherDir, "2019", "photo.jpg")) {
+		t.Errorf("Expected a photo at the same path in another photos directory not to claim the same copy")
+	}
+	if !targets.claim(filepath.Join(otherDir, "2019", "other.jpg")) {
+		t.Errorf("Expected a photo at another path to claim its path")
+	}
+}
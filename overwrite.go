@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+// Files that already have GPS metadata are skipped, unless --overwrite says otherwise. Half-populated GPS metadata,
+// e.g. a latitude without a longitude, is never kept: those files are repaired like files without GPS metadata.
+
+// the field that deletes every GPS tag of a file, in every group, before the new location is written to it
+const clearGPSField = "GPS*"
+
+// the --overwrite policies
+const (
+	overwriteNever   = "never"
+	overwriteAlways  = "always"
+	overwriteIfWorse = "if-worse"
+	overwriteIfZero  = "if-zero"
+)
+
+// returns whether the file has GPS metadata, and whether it has both a latitude and a longitude
+func gpsMetadataState(fileinfo exiftool.FileMetadata) (bool, bool) {
+	hasLatitude, hasLongitude := fileinfo.Fields["GPSLatitude"] != nil, fileinfo.Fields["GPSLongitude"] != nil
+	hasCoordinates := fileinfo.Fields["GPSCoordinates"] != nil
+	return hasLatitude || hasLongitude || hasCoordinates, (hasLatitude && hasLongitude) || hasCoordinates
+}
+
+// reports whether the GPS metadata the file already has is kept according to --overwrite, instead of being replaced
+// by the location found. The location is nil while it isn't known yet, in which case if-worse keeps nothing for now:
+// it's decided again once the location is found.
+func keepExistingGPS(fileinfo exiftool.FileMetadata, location *Location) bool {
+	hasGPS, complete := gpsMetadataState(fileinfo)
+	if !hasGPS || !complete {
+		return false
+	}
+
+	switch *overwrite {
+	case overwriteAlways:
+		return false
+	case overwriteIfZero:
+		latitude, longitude, ok := readGPSCoordinates(fileinfo)
+		return !ok || math.Abs(latitude) > 1e-6 || math.Abs(longitude) > 1e-6
+	case overwriteIfWorse:
+		if location == nil {
+			return false
+		}
+		// without both accuracies it can't be told which one is better
+		existingAccuracy, ok := readGPSPositioningError(fileinfo)
+		return !ok || location.Accuracy <= 0 || float64(location.Accuracy) >= existingAccuracy
+	}
+	return true
+}
+
+// returns the values of the GPS tags the file has, deleted before the new location is written to it
+func existingGPSFields(fileinfo exiftool.FileMetadata) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for tag, value := range fileinfo.Fields {
+		if strings.HasPrefix(tag, "GPS") && value != nil {
+			fields[tag] = value
+		}
+	}
+	return fields
+}
+
+// returns the write of the location to the file, or to its XMP sidecar, with the values the tags had for the
+// journal. The target is the metadata of the file or sidecar written to: when it already has GPS tags, like an
+// outlier fixed by the audit command, they're all deleted before writing, which is reported by clear, and the
+// write only has the new GPS fields.
+func prepareGPSWrite(handler *MediaHandler, fileinfo, target exiftool.FileMetadata, location Location) (exiftool.FileMetadata, map[string]interface{}, bool) {
+	write := handler.prepareWrite(fileinfo, location)
+	oldFields := oldFieldValues(target, write.Fields)
+	existing := existingGPSFields(target)
+	for tag, value := range existing {
+		if _, ok := oldFields[tag]; !ok {
+			oldFields[tag] = value
+		}
+	}
+	return write, oldFields, len(existing) > 0
+}
+
+// writes the files, first deleting the GPS tags of the ones to clear so that none of their old tags, like an old
+// GPSPosition or GPSAltitude, are left next to the new location. exiftool applies the fields of a write in a random
+// order, so the old tags are deleted in a write of their own. A file whose GPS tags can't be deleted isn't written.
+func writeGPSMetadata(et *exiftool.Exiftool, files []exiftool.FileMetadata, clear []bool) {
+	clears := []exiftool.FileMetadata{}
+	clearIndexes := []int{}
+	for i, file := range files {
+		if clear[i] {
+			clears = append(clears, exiftool.FileMetadata{File: file.File, Fields: map[string]interface{}{clearGPSField: nil}})
+			clearIndexes = append(clearIndexes, i)
+		}
+	}
+	if len(clears) > 0 {
+		et.WriteMetadata(clears)
+	}
+	failed := map[int]error{}
+	for j, i := range clearIndexes {
+		if clears[j].Err != nil {
+			failed[i] = clears[j].Err
+		}
+	}
+
+	writes := []exiftool.FileMetadata{}
+	writeIndexes := []int{}
+	for i, file := range files {
+		if _, ok := failed[i]; !ok {
+			writes = append(writes, file)
+			writeIndexes = append(writeIndexes, i)
+		}
+	}
+	et.WriteMetadata(writes)
+	for j, i := range writeIndexes {
+		files[i].Err = writes[j].Err
+	}
+	for i, err := range failed {
+		files[i].Err = err
+	}
+}
+
+// reads the GPSHPositioningError of the file in metres, which exiftool prints like "5 m"
+func readGPSPositioningError(fileinfo exiftool.FileMetadata) (float64, bool) {
+	value, err := fileinfo.GetString("GPSHPositioningError")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	accuracy, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || accuracy <= 0 {
+		return 0, false
+	}
+	return accuracy, true
+}
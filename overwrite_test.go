@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+func TestKeepExistingGPS(t *testing.T) {
+	lisbon := map[string]interface{}{"GPSLatitude": "+38.72230000", "GPSLongitude": "-9.13930000", "GPSHPositioningError": "20 m"}
+	zero := map[string]interface{}{"GPSLatitude": "+0.00000000", "GPSLongitude": "+0.00000000"}
+	onlyLatitude := map[string]interface{}{"GPSLatitude": "+38.72230000"}
+	noAccuracy := map[string]interface{}{"GPSLatitude": "+38.72230000", "GPSLongitude": "-9.13930000"}
+	video := map[string]interface{}{"GPSCoordinates": "38.7223 -9.1393"}
+	zeroVideo := map[string]interface{}{"GPSCoordinates": "+0.00000000, +0.00000000, 0 m"}
+	accurate := &Location{Accuracy: 5}
+	inaccurate := &Location{Accuracy: 50}
+
+	tests := []struct {
+		name     string
+		policy   string
+		fields   map[string]interface{}
+		location *Location
+		expected bool
+	}{
+		{"no gps", overwriteNever, map[string]interface{}{}, nil, false},
+		{"never", overwriteNever, lisbon, nil, true},
+		{"never with half-populated gps", overwriteNever, onlyLatitude, nil, false},
+		{"never with video coordinates", overwriteNever, video, nil, true},
+		{"always", overwriteAlways, lisbon, nil, false},
+		{"if-zero with 0,0", overwriteIfZero, zero, nil, false},
+		{"if-zero with a location", overwriteIfZero, lisbon, nil, true},
+		{"if-zero with 0,0 video coordinates", overwriteIfZero, zeroVideo, nil, false},
+		{"if-zero with video coordinates", overwriteIfZero, video, nil, true},
+		{"if-worse before the location is found", overwriteIfWorse, lisbon, nil, false},
+		{"if-worse with a more accurate location", overwriteIfWorse, lisbon, accurate, false},
+		{"if-worse with a less accurate location", overwriteIfWorse, lisbon, inaccurate, true},
+		{"if-worse with a location of unknown accuracy", overwriteIfWorse, lisbon, &Location{}, true},
+		{"if-worse without GPSHPositioningError", overwriteIfWorse, noAccuracy, accurate, true},
+	}
+
+	// Save original policy
+	originalOverwrite := overwrite
+	defer func() { overwrite = originalOverwrite }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := tt.policy
+			overwrite = &policy
+			fileinfo := exiftool.FileMetadata{File: "photo.jpg", Fields: tt.fields}
+			if result := keepExistingGPS(fileinfo, tt.location); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestExistingGPSFields(t *testing.T) {
+	fileinfo := exiftool.FileMetadata{Fields: map[string]interface{}{
+		"GPSLatitude": "+38.72230000", "GPSPosition": "+38.72230000, -9.13930000", "GPSAltitude": "12 m", "GPSDateStamp": nil, "Make": "Canon",
+	}}
+	fields := existingGPSFields(fileinfo)
+	if len(fields) != 3 || fields["GPSPosition"] != "+38.72230000, -9.13930000" || fields["GPSAltitude"] != "12 m" {
+		t.Errorf("Expected the 3 GPS tags set, got %v", fields)
+	}
+}
+
+func TestPrepareGPSWrite(t *testing.T) {
+	originalOutput := output
+	defer func() { output = originalOutput }()
+	mode := "file"
+	output = &mode
+
+	// an outlier of the audit command, with a wrong position and the altitude and direction of the bad fix
+	outlier := exiftool.FileMetadata{File: "photo.jpg", Fields: map[string]interface{}{
+		"SourceFile":      "photo.jpg",
+		"FileName":        "photo.jpg",
+		"GPSLatitude":     "+41.15790000",
+		"GPSLongitude":    "-8.62910000",
+		"GPSPosition":     "+41.15790000, -8.62910000",
+		"GPSAltitude":     "104 m",
+		"GPSImgDirection": "270",
+	}}
+	location := Location{LatitudeE7: 387223000, LongitudeE7: -91393000}
+
+	write, oldFields, clear := prepareGPSWrite(mediaHandlerFor("photo.jpg"), outlier, outlier, location)
+	if !clear {
+		t.Errorf("Expected the old GPS tags to be deleted before writing")
+	}
+	if write.File != "photo.jpg" {
+		t.Errorf("Expected the photo to be written, got %v", write.File)
+	}
+	for field := range write.Fields {
+		if _, ok := mediaHandlerFor("photo.jpg").gpsFields(location)[field]; !ok {
+			t.Errorf("Expected only the new GPS fields to be written, got %v", field)
+		}
+	}
+	if write.Fields["GPSLatitude"] != float32(38.7223) {
+		t.Errorf("Expected the new latitude to be written, got %v", write.Fields["GPSLatitude"])
+	}
+	for _, tag := range []string{"GPSLatitude", "GPSPosition", "GPSAltitude", "GPSImgDirection"} {
+		if oldFields[tag] != outlier.Fields[tag] {
+			t.Errorf("Expected the old %v to be journaled, got %v", tag, oldFields[tag])
+		}
+	}
+
+	// a raw without a sidecar has nothing to delete
+	raw := exiftool.FileMetadata{File: "IMG_0001.CR2", Fields: map[string]interface{}{}}
+	if _, oldFields, clear := prepareGPSWrite(mediaHandlerFor("IMG_0001.CR2"), raw, exiftool.FileMetadata{}, location); clear || len(oldFields) != 0 {
+		t.Errorf("Expected nothing to delete or journal for a new sidecar, got %v, %v", clear, oldFields)
+	}
+}
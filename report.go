@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With --report the decision about every file walked is written to a JSON or CSV report, along with the capture
+// time and the location found for it, to audit which photos got a location and why the others didn't.
+
+// the decisions reported for a file
+const (
+	reportWritten       = "written"
+	reportWouldWrite    = "would-write"
+	reportSkippedHasGPS = "skipped-has-gps"
+	// the output directory has a copy of the file from a previous run, which isn't overwritten
+	reportSkippedOutputExists = "skipped-output-exists"
+	reportNoDate              = "no-date"
+	reportNoLocation          = "no-location"
+	reportUnsupported         = "unsupported"
+	reportUnchanged           = "unchanged"
+	reportError               = "error"
+)
+
+type ReportEntry struct {
+	File     string `json:"file"`
+	Decision string `json:"decision"`
+	// why the file failed, for the error decision
+	Reason      string     `json:"reason,omitempty"`
+	CaptureTime *time.Time `json:"captureTime,omitempty"`
+	TimeSource  string     `json:"timeSource,omitempty"`
+	// the time of the location found for the file, and how far it is from the capture time
+	LocationTime     *time.Time `json:"locationTime,omitempty"`
+	TimeDeltaSeconds *float64   `json:"timeDeltaSeconds,omitempty"`
+	Latitude         *float64   `json:"latitude,omitempty"`
+	Longitude        *float64   `json:"longitude,omitempty"`
+	// zero when unknown
+	Accuracy int `json:"accuracy,omitempty"`
+}
+
+// sets the location found for the file taken at captureTime
+func (e *ReportEntry) setLocation(location Location, captureTime time.Time) {
+	locationTime := location.Timestamp
+	delta := location.timeDifference(captureTime).Seconds()
+	latitude, longitude := location.latitude(), location.longitude()
+	e.LocationTime = &locationTime
+	e.TimeDeltaSeconds = &delta
+	e.Latitude = &latitude
+	e.Longitude = &longitude
+	e.Accuracy = location.Accuracy
+}
+
+// checks that the report can be written in the format of its extension, json or csv
+func validateReportPath(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".csv":
+		return nil
+	}
+	return fmt.Errorf("%v must end in .json or .csv", path)
+}
+
+// writes the report as JSON or CSV, depending on the extension of the path
+func writeReport(path string, entries []ReportEntry) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entries); err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"file", "decision", "reason", "captureTime", "timeSource", "locationTime", "timeDeltaSeconds", "latitude", "longitude", "accuracy"})
+	for _, entry := range entries {
+		accuracy := ""
+		if entry.Accuracy > 0 {
+			accuracy = strconv.Itoa(entry.Accuracy)
+		}
+		writer.Write([]string{
+			entry.File,
+			entry.Decision,
+			entry.Reason,
+			formatReportTime(entry.CaptureTime),
+			entry.TimeSource,
+			formatReportTime(entry.LocationTime),
+			formatReportFloat(entry.TimeDeltaSeconds),
+			formatReportFloat(entry.Latitude),
+			formatReportFloat(entry.Longitude),
+			accuracy,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// formats the time for the CSV report, empty when unknown
+func formatReportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// formats the number for the CSV report, empty when unknown
+func formatReportFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
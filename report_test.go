@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteReport(t *testing.T) {
+	captureTime := time.Date(2019, 4, 19, 20, 1, 28, 0, time.UTC)
+	written := ReportEntry{File: "photos/IMG_0001.jpg", CaptureTime: &captureTime, TimeSource: "DateTimeOriginal"}
+	written.setLocation(Location{LatitudeE7: 387223000, LongitudeE7: -91393000, Timestamp: captureTime.Add(-90 * time.Second), Accuracy: 12}, captureTime)
+	written.Decision = reportWritten
+	entries := []ReportEntry{
+		written,
+		{File: "photos/IMG_0002.jpg", Decision: reportNoDate, Reason: "no date time found"},
+		{File: "photos/notes.txt", Decision: reportUnsupported},
+	}
+
+	tmpDir := t.TempDir()
+
+	jsonPath := filepath.Join(tmpDir, "report.json")
+	if err := writeReport(jsonPath, entries); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	bytes, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read the report: %v", err)
+	}
+	var decoded []ReportEntry
+	if err := json.Unmarshal(bytes, &decoded); err != nil {
+		t.Fatalf("Failed to decode the report: %v", err)
+	}
+	if len(decoded) != 3 {
+		t.Fatalf("Expected 3 entries, got %v", len(decoded))
+	}
+	if *decoded[0].TimeDeltaSeconds != 90 || *decoded[0].Latitude != 38.7223 || decoded[0].Accuracy != 12 || !decoded[0].LocationTime.Equal(captureTime.Add(-90*time.Second)) {
+		t.Errorf("Expected the location of the written file, got %+v", decoded[0])
+	}
+	if decoded[1].Decision != reportNoDate || decoded[1].Reason != "no date time found" || decoded[1].CaptureTime != nil {
+		t.Errorf("Expected the no-date file without a capture time, got %+v", decoded[1])
+	}
+
+	csvPath := filepath.Join(tmpDir, "report.CSV")
+	if err := writeReport(csvPath, entries); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	file, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatalf("Failed to open the report: %v", err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read the report: %v", err)
+	}
+	expected := [][]string{
+		{"file", "decision", "reason", "captureTime", "timeSource", "locationTime", "timeDeltaSeconds", "latitude", "longitude", "accuracy"},
+		{"photos/IMG_0001.jpg", "written", "", "2019-04-19T20:01:28Z", "DateTimeOriginal", "2019-04-19T19:59:58Z", "90", "38.7223", "-9.1393", "12"},
+		{"photos/IMG_0002.jpg", "no-date", "no date time found", "", "", "", "", "", "", ""},
+		{"photos/notes.txt", "unsupported", "", "", "", "", "", "", "", ""},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %v rows, got %v", len(expected), len(records))
+	}
+	for i := range expected {
+		for j := range expected[i] {
+			if records[i][j] != expected[i][j] {
+				t.Errorf("Expected row %v column %v to be %q, got %q", i, j, expected[i][j], records[i][j])
+			}
+		}
+	}
+}
+
+func TestValidateReportPath(t *testing.T) {
+	for path, valid := range map[string]bool{"report.json": true, "out/report.CSV": true, "report.txt": false, "report": false} {
+		if err := validateReportPath(path); (err == nil) != valid {
+			t.Errorf("Expected validateReportPath(%v) to be valid: %v, got error %v", path, valid, err)
+		}
+	}
+}
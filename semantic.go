@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// The Semantic Location History takeout has one YYYY/YYYY_MONTH.json file per month with a timelineObjects array.
+// Each entry is either a placeVisit, a stay at a place for some time, or an activitySegment, a trip between two places.
+
+type SemanticTimelineObject struct {
+	PlaceVisit      *SemanticPlaceVisit      `json:"placeVisit"`
+	ActivitySegment *SemanticActivitySegment `json:"activitySegment"`
+}
+
+type SemanticPlaceVisit struct {
+	Location SemanticLocation `json:"location"`
+	Duration SemanticDuration `json:"duration"`
+}
+
+type SemanticActivitySegment struct {
+	StartLocation SemanticLocation `json:"startLocation"`
+	EndLocation   SemanticLocation `json:"endLocation"`
+	Duration      SemanticDuration `json:"duration"`
+	WaypointPath  struct {
+		Waypoints []SemanticPoint `json:"waypoints"`
+	} `json:"waypointPath"`
+	SimplifiedRawPath struct {
+		Points []SemanticPoint `json:"points"`
+	} `json:"simplifiedRawPath"`
+}
+
+type SemanticLocation struct {
+	LatitudeE7  *int `json:"latitudeE7"`
+	LongitudeE7 *int `json:"longitudeE7"`
+}
+
+type SemanticPoint struct {
+	LatE7       int              `json:"latE7"`
+	LngE7       int              `json:"lngE7"`
+	Timestamp   time.Time        `json:"timestamp"`
+	TimestampMs semanticMillisTs `json:"timestampMs"`
+}
+
+// older exports use startTimestampMs/endTimestampMs with milliseconds since the epoch, newer ones RFC 3339 timestamps
+type SemanticDuration struct {
+	StartTimestamp   time.Time        `json:"startTimestamp"`
+	EndTimestamp     time.Time        `json:"endTimestamp"`
+	StartTimestampMs semanticMillisTs `json:"startTimestampMs"`
+	EndTimestampMs   semanticMillisTs `json:"endTimestampMs"`
+}
+
+func (d SemanticDuration) start() time.Time {
+	return firstNonZeroTime(d.StartTimestamp, time.Time(d.StartTimestampMs))
+}
+
+func (d SemanticDuration) end() time.Time {
+	return firstNonZeroTime(d.EndTimestamp, time.Time(d.EndTimestampMs))
+}
+
+// a timestamp encoded as a string with the milliseconds since the epoch
+type semanticMillisTs time.Time
+
+func (ts *semanticMillisTs) UnmarshalJSON(b []byte) error {
+	var millis string
+	if err := json.Unmarshal(b, &millis); err != nil {
+		return err
+	}
+	value, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return err
+	}
+	*ts = semanticMillisTs(time.UnixMilli(value).UTC())
+	return nil
+}
+
+func firstNonZeroTime(times ...time.Time) time.Time {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// decodes an entry of the timelineObjects array from a Semantic Location History file.
+// Visits become intervals covering the whole stay. For activity segments the start and end locations,
+// the timestamped raw path points and the waypoints become ordinary points. Waypoints carry no time
+// so they are spread evenly over the duration of the segment.
+func decodeSemanticTimelineObject(decoder *json.Decoder, insert func(Location)) error {
+	var object SemanticTimelineObject
+	if err := decoder.Decode(&object); err != nil {
+		return err
+	}
+
+	if visit := object.PlaceVisit; visit != nil && visit.Location.hasCoordinates() {
+		start, end := visit.Duration.start(), visit.Duration.end()
+		if !start.IsZero() && !end.Before(start) {
+			insert(Location{
+				LatitudeE7:   *visit.Location.LatitudeE7,
+				LongitudeE7:  *visit.Location.LongitudeE7,
+				Timestamp:    start,
+				EndTimestamp: end,
+			})
+		}
+	}
+
+	if segment := object.ActivitySegment; segment != nil {
+		start, end := segment.Duration.start(), segment.Duration.end()
+		if segment.StartLocation.hasCoordinates() && !start.IsZero() {
+			insert(Location{LatitudeE7: *segment.StartLocation.LatitudeE7, LongitudeE7: *segment.StartLocation.LongitudeE7, Timestamp: start})
+		}
+		if segment.EndLocation.hasCoordinates() && !end.IsZero() {
+			insert(Location{LatitudeE7: *segment.EndLocation.LatitudeE7, LongitudeE7: *segment.EndLocation.LongitudeE7, Timestamp: end})
+		}
+
+		for _, p := range segment.SimplifiedRawPath.Points {
+			if timestamp := firstNonZeroTime(p.Timestamp, time.Time(p.TimestampMs)); !timestamp.IsZero() {
+				insert(Location{LatitudeE7: p.LatE7, LongitudeE7: p.LngE7, Timestamp: timestamp})
+			}
+		}
+
+		if waypoints := segment.WaypointPath.Waypoints; len(waypoints) > 0 && !start.IsZero() && end.After(start) {
+			step := end.Sub(start) / time.Duration(len(waypoints)+1)
+			for i, p := range waypoints {
+				insert(Location{LatitudeE7: p.LatE7, LongitudeE7: p.LngE7, Timestamp: start.Add(step * time.Duration(i+1))})
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l SemanticLocation) hasCoordinates() bool {
+	return l.LatitudeE7 != nil && l.LongitudeE7 != nil
+}
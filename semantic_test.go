@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadSemanticLocationHistory(t *testing.T) {
+	// Save original tolerance and the longest interval seen so far
+	originalTolerance, originalLongestInterval := tolerance, longestInterval
+	defer func() { tolerance, longestInterval = originalTolerance, originalLongestInterval }()
+
+	testTolerance := 30 * time.Minute
+	tolerance = &testTolerance
+
+	dir := filepath.Join(t.TempDir(), "Semantic Location History")
+	if err := os.MkdirAll(filepath.Join(dir, "2019"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	april := `{"timelineObjects": [
+		{"placeVisit": {
+			"location": {"latitudeE7": 395107349, "longitudeE7": -91427899, "name": "Home"},
+			"duration": {"startTimestampMs": "1555704000000", "endTimestampMs": "1555732800000"}
+		}},
+		{"activitySegment": {
+			"startLocation": {"latitudeE7": 395107349, "longitudeE7": -91427899},
+			"endLocation": {"latitudeE7": 258135945, "longitudeE7": 81338558},
+			"duration": {"startTimestamp": "2019-04-20T04:00:00Z", "endTimestamp": "2019-04-20T07:00:00Z"},
+			"waypointPath": {"waypoints": [{"latE7": 300000000, "lngE7": 0}, {"latE7": 280000000, "lngE7": 50000000}]}
+		}}
+	]}`
+	may := `{"timelineObjects": [
+		{"placeVisit": {
+			"location": {"latitudeE7": 258135945, "longitudeE7": 81338558},
+			"duration": {"startTimestamp": "2019-05-01T10:00:00Z", "endTimestamp": "2019-05-01T12:00:00Z"}
+		}}
+	]}`
+	if err := os.WriteFile(filepath.Join(dir, "2019", "2019_APRIL.json"), []byte(april), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2019", "2019_MAY.json"), []byte(may), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+
+	locations, err := readLocations(dir)
+	if err != nil {
+		t.Fatalf("Failed to read locations: %v", err)
+	}
+
+	// 2 visits, the segment start and end and its 2 waypoints
+	if locations.Len() != 6 {
+		t.Fatalf("Expected 6 locations, got %d", locations.Len())
+	}
+
+	tests := []struct {
+		name        string
+		searchTime  time.Time
+		expectFound bool
+		expectedLat int
+	}{
+		{
+			// the visit runs from 20:00 to 04:00, way past the tolerance on both ends
+			name:        "InsideLongVisit",
+			searchTime:  time.Date(2019, 4, 20, 0, 0, 0, 0, time.UTC),
+			expectFound: true,
+			expectedLat: 395107349,
+		},
+		{
+			name:        "ShortlyAfterVisit",
+			searchTime:  time.Date(2019, 5, 1, 12, 20, 0, 0, time.UTC),
+			expectFound: true,
+			expectedLat: 258135945,
+		},
+		{
+			name:        "Waypoint",
+			searchTime:  time.Date(2019, 4, 20, 5, 0, 0, 0, time.UTC),
+			expectFound: true,
+			expectedLat: 300000000,
+		},
+		{
+			name:        "OutsideVisit",
+			searchTime:  time.Date(2019, 5, 1, 13, 0, 0, 0, time.UTC),
+			expectFound: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := findLocationFromDate(locations, tt.searchTime)
+
+			if tt.expectFound && result == nil {
+				t.Fatal("Expected to find location, got nil")
+			} else if !tt.expectFound && result != nil {
+				t.Fatalf("Expected no location, got %+v", result)
+			}
+
+			if tt.expectFound && result.LatitudeE7 != tt.expectedLat {
+				t.Errorf("Expected latitude %d, got %d", tt.expectedLat, result.LatitudeE7)
+			}
+		})
+	}
+}
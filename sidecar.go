@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Google Photos exports every photo in the Takeout with a JSON sidecar holding the metadata it knows about the photo,
+// which is kept even when the photo's own exif was stripped, e.g. for images received in chat apps.
+//
+// The sidecar is named after the photo, but not always exactly: Takeout cuts the name before ".json" to 46 characters,
+// puts the "(1)" of duplicate names after the photo's extension and has edited copies share the original's sidecar.
+
+type TakeoutSidecar struct {
+	Title          string `json:"title"`
+	PhotoTakenTime struct {
+		// seconds since the unix epoch, as a string
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+	// the location Google Photos has for the photo, including the ones added or edited in the app. Zero when unknown
+	GeoData TakeoutGeoData `json:"geoData"`
+	// the location that was in the photo's exif when it was uploaded
+	GeoDataExif TakeoutGeoData `json:"geoDataExif"`
+}
+
+type TakeoutGeoData struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+// the longest name Takeout gives a sidecar, without the ".json"
+const maxSidecarNameLength = 46
+
+// matches the "(1)" Takeout adds before the extension of duplicate names
+var duplicateSuffixPattern = regexp.MustCompile(`^(.*)(\([0-9]+\))(\.[^.]*)$`)
+
+// returns the path of the sidecar of the photo, empty if it has none
+func findTakeoutSidecar(photoPath string) string {
+	dir := filepath.Dir(photoPath)
+	for _, name := range sidecarNames(filepath.Base(photoPath)) {
+		candidate := filepath.Join(dir, name)
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+
+	// Takeout also cuts ".supplemental-metadata" short on names that aren't that long, e.g. photo.jpg.supplemental-metad.json
+	photoName, counter := splitDuplicateCounter(filepath.Base(photoPath))
+	candidates, _ := filepath.Glob(filepath.Join(dir, escapeGlob(photoName)+".*"+escapeGlob(counter)+".json"))
+	for _, candidate := range candidates {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(candidate), photoName+"."), counter+".json")
+		if suffix != "" && strings.HasPrefix("supplemental-metadata", suffix) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// splits the "(1)" Takeout adds to duplicate names from the name
+func splitDuplicateCounter(photoName string) (string, string) {
+	if match := duplicateSuffixPattern.FindStringSubmatch(photoName); match != nil {
+		return match[1] + match[3], match[2]
+	}
+	return photoName, ""
+}
+
+func escapeGlob(name string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+	return replacer.Replace(name)
+}
+
+// returns the names the sidecar of the photo can have, most likely first
+func sidecarNames(photoName string) []string {
+	photoName, counter := splitDuplicateCounter(photoName)
+
+	originals := []string{photoName}
+	extension := filepath.Ext(photoName)
+	if base := strings.TrimSuffix(photoName, extension); strings.HasSuffix(base, "-edited") {
+		originals = append(originals, strings.TrimSuffix(base, "-edited")+extension)
+	}
+
+	names := []string{}
+	for _, original := range originals {
+		for _, suffix := range []string{"", ".supplemental-metadata"} {
+			name := []rune(original + suffix)
+			if len(name) > maxSidecarNameLength {
+				name = name[:maxSidecarNameLength]
+			}
+			names = append(names, string(name)+counter+".json")
+		}
+	}
+	return names
+}
+
+// reports whether the archive entry is a sidecar of a photo, as opposed to the other JSON files of a Takeout
+func isSidecarEntry(name string) bool {
+	return strings.ToLower(filepath.Ext(name)) == ".json" && !isLocationHistoryEntry(name)
+}
+
+func readTakeoutSidecar(sidecarPath string) (*TakeoutSidecar, error) {
+	bytes, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var sidecar TakeoutSidecar
+	if err := json.Unmarshal(bytes, &sidecar); err != nil {
+		return nil, err
+	}
+	return &sidecar, nil
+}
+
+// returns the location in the sidecar of the photo, nil if it has no sidecar or the sidecar has no location
+func findSidecarLocation(photoPath string, timestamp time.Time) *Location {
+	sidecarPath := findTakeoutSidecar(photoPath)
+	if sidecarPath == "" {
+		return nil
+	}
+	sidecar, err := readTakeoutSidecar(sidecarPath)
+	if err != nil {
+		logrus.Warnf("Ignoring the sidecar %v: %v", sidecarPath, err)
+		return nil
+	}
+	return sidecar.location(timestamp)
+}
+
+// returns the location of the photo according to the sidecar, nil if it has none
+func (s *TakeoutSidecar) location(timestamp time.Time) *Location {
+	for _, geoData := range []TakeoutGeoData{s.GeoData, s.GeoDataExif} {
+		if geoData.Latitude == 0 && geoData.Longitude == 0 {
+			continue
+		}
+		location := locationFromDegrees(geoData.Latitude, geoData.Longitude, timestamp)
+		if geoData.Altitude != 0 {
+			altitude := geoData.Altitude
+			location.Altitude = &altitude
+		}
+		return &location
+	}
+	return nil
+}
+
+// returns the time the photo was taken according to the sidecar, zero if it isn't set
+func (s *TakeoutSidecar) takenTime() (time.Time, error) {
+	if s.PhotoTakenTime.Timestamp == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(s.PhotoTakenTime.Timestamp, 10, 64)
+	if err != nil || seconds == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindTakeoutSidecar(t *testing.T) {
+	longName := "Screenshot_20190419-200828_Google Maps Navigation.jpg"
+
+	tests := []struct {
+		name     string
+		photo    string
+		sidecar  string
+		expected bool
+	}{
+		{"Plain", "IMG_20190419_200828.jpg", "IMG_20190419_200828.jpg.json", true},
+		{"SupplementalMetadata", "IMG_20190419_200828.jpg", "IMG_20190419_200828.jpg.supplemental-metadata.json", true},
+		{"TruncatedSupplementalMetadata", "IMG_20190419_200828.jpg", "IMG_20190419_200828.jpg.supplemental-metad.json", true},
+		{"TruncatedName", longName, "Screenshot_20190419-200828_Google Maps Navigat.json", true},
+		{"Duplicate", "IMG_20190419_200828(1).jpg", "IMG_20190419_200828.jpg(1).json", true},
+		{"Edited", "IMG_20190419_200828-edited.jpg", "IMG_20190419_200828.jpg.json", true},
+		{"OtherPhoto", "IMG_20190419_200828.jpg", "IMG_20190419_200829.jpg.json", false},
+		{"DuplicateOfOther", "IMG_20190419_200828(1).jpg", "IMG_20190419_200828.jpg.json", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			sidecarPath := filepath.Join(tmpDir, tt.sidecar)
+			if err := os.WriteFile(sidecarPath, []byte(`{}`), 0644); err != nil {
+				t.Fatalf("Failed to create temp file: %v", err)
+			}
+
+			result := findTakeoutSidecar(filepath.Join(tmpDir, tt.photo))
+			if tt.expected && result != sidecarPath {
+				t.Errorf("Expected %v, got %q", sidecarPath, result)
+			}
+			if !tt.expected && result != "" {
+				t.Errorf("Expected no sidecar, got %v", result)
+			}
+		})
+	}
+}
+
+func TestFindSidecarLocation(t *testing.T) {
+	tmpDir := t.TempDir()
+	timestamp := time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		sidecar   string
+		latitude  int
+		longitude int
+		expected  bool
+	}{
+		{
+			name:      "GeoData",
+			sidecar:   `{"geoData": {"latitude": 38.7223, "longitude": -9.1393, "altitude": 45.5}, "geoDataExif": {"latitude": 40.4168, "longitude": -3.7038}}`,
+			latitude:  387223000,
+			longitude: -91393000,
+			expected:  true,
+		},
+		{
+			name:      "GeoDataExif",
+			sidecar:   `{"geoData": {"latitude": 0.0, "longitude": 0.0}, "geoDataExif": {"latitude": 40.4168, "longitude": -3.7038}}`,
+			latitude:  404168000,
+			longitude: -37038000,
+			expected:  true,
+		},
+		{
+			name:    "NoLocation",
+			sidecar: `{"geoData": {"latitude": 0.0, "longitude": 0.0, "altitude": 0.0}}`,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			photo := filepath.Join(tmpDir, tt.name+".jpg")
+			if err := os.WriteFile(photo+".json", []byte(tt.sidecar), 0644); err != nil {
+				t.Fatalf("Failed to create temp file: %v", err)
+			}
+
+			location := findSidecarLocation(photo, timestamp)
+			if !tt.expected {
+				if location != nil {
+					t.Errorf("Expected no location, got %+v", location)
+				}
+				return
+			}
+			if location == nil {
+				t.Fatalf("Expected a location")
+			}
+			if location.LatitudeE7 != tt.latitude || location.LongitudeE7 != tt.longitude {
+				t.Errorf("Expected %v, %v, got %v, %v", tt.latitude, tt.longitude, location.LatitudeE7, location.LongitudeE7)
+			}
+			if !location.Timestamp.Equal(timestamp) {
+				t.Errorf("Expected timestamp %v, got %v", timestamp, location.Timestamp)
+			}
+			if i == 0 && (location.Altitude == nil || *location.Altitude != 45.5) {
+				t.Errorf("Expected altitude 45.5, got %v", location.Altitude)
+			}
+		})
+	}
+
+	if location := findSidecarLocation(filepath.Join(tmpDir, "missing.jpg"), timestamp); location != nil {
+		t.Errorf("Expected no location without a sidecar, got %+v", location)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The state of every file processed is kept in a state file, one JSON entry per line appended as soon as the
+// decision about the file is made, the last entry of a file winning. Reruns skip the files that didn't change
+// since their last entry, which also resumes an interrupted run where it stopped.
+
+// the decisions recorded for a file
+const (
+	decisionWritten       = "written"
+	decisionGPSAlreadySet = "gps-already-set"
+	decisionNoDateTime    = "no-date-time"
+	decisionNoLocation    = "no-location"
+	decisionReadError     = "read-error"
+	decisionWriteError    = "write-error"
+)
+
+// the number of files read and written at a time, the state is saved after each batch
+const batchSize = 500
+
+type FileState struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Hash     string    `json:"hash"`
+	Decision string    `json:"decision"`
+	Run      string    `json:"run"`
+	Time     time.Time `json:"time"`
+}
+
+type StateStore struct {
+	files map[string]FileState
+	file  *os.File
+}
+
+// returns the --state path, by default in the output directory. Empty when there's no state to keep
+func statePath() string {
+	if *stateFile != "" {
+		return *stateFile
+	}
+	if *outputDir != "" {
+		return filepath.Join(*outputDir, ".google-takeout-photo-location-fixer-state.jsonl")
+	}
+	return ""
+}
+
+// reads the state file, creating it if it doesn't exist, and opens it to record the new decisions
+func openStateStore(path string) (*StateStore, error) {
+	store := &StateStore{files: map[string]FileState{}}
+
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			if len(strings.TrimSpace(scanner.Text())) == 0 {
+				continue
+			}
+			var state FileState
+			if err := json.Unmarshal(scanner.Bytes(), &state); err != nil {
+				// the last line is cut short when a run is killed while writing it
+				logrus.Warnf("Ignoring line %v of the state file %v: %v", line, path, err)
+				continue
+			}
+			store.files[state.Path] = state
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	store.file = file
+
+	// end the line a killed run left partial, for the next entry to start on its own line
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if reader, err := os.Open(path); err == nil {
+			_, err = reader.ReadAt(last, info.Size()-1)
+			reader.Close()
+			if err == nil && last[0] != '\n' {
+				if _, err := file.Write([]byte{'\n'}); err != nil {
+					file.Close()
+					return nil, err
+				}
+			}
+		}
+	}
+	return store, nil
+}
+
+func (s *StateStore) Close() error {
+	return s.file.Close()
+}
+
+// reports whether the file was already processed and didn't change since. A file with a different modification
+// time but the same content, like a photo extracted again from an archive, didn't change.
+// Files whose last decision is one of the retried decisions are processed again, e.g. the ones that couldn't be written.
+func (s *StateStore) isDone(path string, retry map[string]bool) bool {
+	state, ok := s.last(path, retry)
+	if !ok {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != state.Size {
+		return false
+	}
+	if info.ModTime().Equal(state.ModTime) {
+		return true
+	}
+	hash, err := fileHash(path)
+	return err == nil && hash == state.Hash
+}
+
+// returns the last state recorded for the path, unless there's none or its decision is one of the retried decisions
+func (s *StateStore) last(path string, retry map[string]bool) (FileState, bool) {
+	if s == nil {
+		return FileState{}, false
+	}
+	state, ok := s.files[path]
+	if !ok || retry[state.Decision] {
+		return FileState{}, false
+	}
+	return state, true
+}
+
+// records the decision about the file, with its size, modification time and hash as they are now.
+// Does nothing without a state store, e.g. in a dry run.
+func (s *StateStore) record(path, decision, run string) error {
+	if s == nil {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	hash, err := fileHash(path)
+	if err != nil {
+		return err
+	}
+	return s.recordState(FileState{Path: path, Size: info.Size(), ModTime: info.ModTime(), Hash: hash, Decision: decision, Run: run})
+}
+
+// records the decision about a photo in an archive, with the size, modification time and hash of its entry
+func (s *StateStore) recordArchivePhoto(photo *ArchivePhoto, decision, run string) error {
+	if s == nil {
+		return nil
+	}
+	return s.recordState(FileState{Path: photo.statePath(), Size: photo.Size, ModTime: photo.ModTime, Hash: photo.Hash, Decision: decision, Run: run})
+}
+
+func (s *StateStore) recordState(state FileState) error {
+	state.Time = time.Now().UTC()
+	bytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(bytes, '\n')); err != nil {
+		return err
+	}
+	s.files[state.Path] = state
+	return nil
+}
+
+// reports whether the photo in an archive was already processed and its entry didn't change since, like isDone.
+// The hash of the photo is only known once it was read, the modification time of the entry is compared until then.
+func (s *StateStore) isArchivePhotoDone(photo *ArchivePhoto, retry map[string]bool) bool {
+	state, ok := s.last(photo.statePath(), retry)
+	if !ok || photo.Size != state.Size {
+		return false
+	}
+	return photo.ModTime.Equal(state.ModTime) || (photo.Hash != "" && photo.Hash == state.Hash)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateStore(t *testing.T) {
+	tmpDir := t.TempDir()
+	statePath := filepath.Join(tmpDir, "output", "state.jsonl")
+
+	photos := map[string]string{}
+	for _, name := range []string{"written.jpg", "nolocation.jpg", "failed.jpg", "unreadable.jpg", "touched.jpg", "changed.jpg", "new.jpg"} {
+		photos[name] = filepath.Join(tmpDir, name)
+		if err := os.WriteFile(photos[name], []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+	}
+
+	store, err := openStateStore(statePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for name, decision := range map[string]string{
+		"written.jpg":    decisionWritten,
+		"nolocation.jpg": decisionNoLocation,
+		"failed.jpg":     decisionWriteError,
+		"unreadable.jpg": decisionReadError,
+		"touched.jpg":    decisionGPSAlreadySet,
+		"changed.jpg":    decisionGPSAlreadySet,
+	} {
+		if err := store.record(photos[name], decision, "run1"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	store.Close()
+
+	// a new modification time with the same content, and new content of the same size
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(photos["touched.jpg"], later, later); err != nil {
+		t.Fatalf("Failed to set the modification time: %v", err)
+	}
+	if err := os.WriteFile(photos["changed.jpg"], []byte("CHANGED.jpg"), 0644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	if err := os.Chtimes(photos["changed.jpg"], later, later); err != nil {
+		t.Fatalf("Failed to set the modification time: %v", err)
+	}
+
+	// a run killed while writing the state leaves a partial line
+	file, err := os.OpenFile(statePath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open the state file: %v", err)
+	}
+	file.WriteString(`{"path": "new.jpg", "si`)
+	file.Close()
+
+	store, err = openStateStore(statePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer store.Close()
+
+	retryFailed := map[string]bool{decisionReadError: true, decisionWriteError: true}
+	retryUndecided := map[string]bool{decisionReadError: true, decisionWriteError: true, decisionNoDateTime: true, decisionNoLocation: true}
+	tests := []struct {
+		name     string
+		retry    map[string]bool
+		expected bool
+	}{
+		{"written.jpg", retryFailed, true},
+		{"nolocation.jpg", retryFailed, true},
+		{"nolocation.jpg", retryUndecided, false},
+		{"written.jpg", retryUndecided, true},
+		{"failed.jpg", retryFailed, false},
+		{"unreadable.jpg", retryFailed, false},
+		{"touched.jpg", retryFailed, true},
+		{"touched.jpg", map[string]bool{decisionGPSAlreadySet: true}, false},
+		{"changed.jpg", retryFailed, false},
+		{"new.jpg", retryFailed, false},
+	}
+
+	for _, tt := range tests {
+		if result := store.isDone(photos[tt.name], tt.retry); result != tt.expected {
+			t.Errorf("Expected isDone(%v, %v) to be %v, got %v", tt.name, tt.retry, tt.expected, result)
+		}
+	}
+
+	if err := store.record(photos["new.jpg"], decisionWritten, "run2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store.Close()
+	if store, err = openStateStore(statePath); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !store.isDone(photos["new.jpg"], retryFailed) {
+		t.Errorf("Expected the entry recorded after a partial line to be read back")
+	}
+
+	var noStore *StateStore
+	if noStore.isDone(photos["written.jpg"], nil) || noStore.record(photos["written.jpg"], decisionWritten, "run2") != nil {
+		t.Errorf("Expected no state without a state store")
+	}
+}
+
+func TestStateStoreArchivePhoto(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.jsonl")
+	store, err := openStateStore(statePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer store.Close()
+
+	modTime := time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC)
+	photo := &ArchivePhoto{Archive: "takeout-001.zip", Name: "Takeout/Google Photos/photo.jpg", Size: 10, ModTime: modTime, Hash: "abc"}
+	if store.isArchivePhotoDone(photo, nil) {
+		t.Errorf("Expected a photo without state not to be done")
+	}
+	if err := store.recordArchivePhoto(photo, decisionWritten, "run1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	retryFailed := map[string]bool{decisionReadError: true, decisionWriteError: true}
+	tests := []struct {
+		name     string
+		photo    ArchivePhoto
+		expected bool
+	}{
+		{"SameEntry", ArchivePhoto{Size: 10, ModTime: modTime}, true},
+		{"SameContentInAnotherExport", ArchivePhoto{Size: 10, ModTime: modTime.Add(time.Hour), Hash: "abc"}, true},
+		{"NotReadYet", ArchivePhoto{Size: 10, ModTime: modTime.Add(time.Hour)}, false},
+		{"Changed", ArchivePhoto{Size: 10, ModTime: modTime.Add(time.Hour), Hash: "def"}, false},
+		{"Resized", ArchivePhoto{Size: 11, ModTime: modTime}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidate := tt.photo
+			candidate.Archive, candidate.Name = photo.Archive, photo.Name
+			if result := store.isArchivePhotoDone(&candidate, retryFailed); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
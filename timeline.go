@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeline.json is the export of the on-device Location History (Google Maps > Timeline > Export).
+// Coordinates are strings in the "lat°, lng°" form and timestamps carry the local offset.
+
+type TimelineSegment struct {
+	StartTime    time.Time           `json:"startTime"`
+	EndTime      time.Time           `json:"endTime"`
+	TimelinePath []TimelinePathPoint `json:"timelinePath"`
+	Visit        *TimelineVisit      `json:"visit"`
+	Activity     *TimelineActivity   `json:"activity"`
+}
+
+type TimelinePathPoint struct {
+	Point string    `json:"point"`
+	Time  time.Time `json:"time"`
+}
+
+type TimelineVisit struct {
+	TopCandidate struct {
+		PlaceLocation struct {
+			LatLng string `json:"latLng"`
+		} `json:"placeLocation"`
+	} `json:"topCandidate"`
+}
+
+type TimelineActivity struct {
+	Start struct {
+		LatLng string `json:"latLng"`
+	} `json:"start"`
+	End struct {
+		LatLng string `json:"latLng"`
+	} `json:"end"`
+}
+
+type TimelineRawSignal struct {
+	Position *struct {
+		LatLng               string    `json:"LatLng"`
+		AccuracyMeters       int       `json:"accuracyMeters"`
+		AltitudeMeters       *float64  `json:"altitudeMeters"`
+		SpeedMetersPerSecond *float64  `json:"speedMetersPerSecond"`
+		Timestamp            time.Time `json:"timestamp"`
+	} `json:"position"`
+}
+
+// decodes an entry of the semanticSegments array from Timeline.json.
+// Path points are used as they are, visits become intervals and activities contribute their start and end positions.
+func decodeTimelineSegment(decoder *json.Decoder, insert func(Location)) error {
+	var segment TimelineSegment
+	if err := decoder.Decode(&segment); err != nil {
+		return err
+	}
+
+	for _, p := range segment.TimelinePath {
+		if err := insertTimelinePoint(p.Point, p.Time, insert); err != nil {
+			return err
+		}
+	}
+
+	if segment.Visit != nil && segment.Visit.TopCandidate.PlaceLocation.LatLng != "" {
+		location, err := parseTimelineLocation(segment.Visit.TopCandidate.PlaceLocation.LatLng, segment.StartTime)
+		if err != nil {
+			return err
+		}
+		location.EndTimestamp = segment.EndTime.UTC()
+		insert(location)
+	}
+
+	if segment.Activity != nil {
+		if err := insertTimelinePoint(segment.Activity.Start.LatLng, segment.StartTime, insert); err != nil {
+			return err
+		}
+		if err := insertTimelinePoint(segment.Activity.End.LatLng, segment.EndTime, insert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodes an entry of the rawSignals array from Timeline.json. Only position signals carry a location.
+func decodeTimelineRawSignal(decoder *json.Decoder, insert func(Location)) error {
+	var signal TimelineRawSignal
+	if err := decoder.Decode(&signal); err != nil {
+		return err
+	}
+
+	if signal.Position == nil || signal.Position.LatLng == "" || signal.Position.Timestamp.IsZero() {
+		return nil
+	}
+
+	location, err := parseTimelineLocation(signal.Position.LatLng, signal.Position.Timestamp)
+	if err != nil {
+		return err
+	}
+	location.Accuracy = signal.Position.AccuracyMeters
+	location.Altitude = signal.Position.AltitudeMeters
+	location.Velocity = signal.Position.SpeedMetersPerSecond
+	insert(location)
+	return nil
+}
+
+func insertTimelinePoint(latLng string, timestamp time.Time, insert func(Location)) error {
+	if latLng == "" || timestamp.IsZero() {
+		return nil
+	}
+
+	location, err := parseTimelineLocation(latLng, timestamp)
+	if err != nil {
+		return err
+	}
+	insert(location)
+	return nil
+}
+
+func parseTimelineLocation(latLng string, timestamp time.Time) (Location, error) {
+	latitude, longitude, err := parseTimelineLatLng(latLng)
+	if err != nil {
+		return Location{}, err
+	}
+
+	return locationFromDegrees(latitude, longitude, timestamp), nil
+}
+
+// parses coordinates in the "39.5107349°, -9.1427899°" form used by Timeline.json
+func parseTimelineLatLng(latLng string) (float64, float64, error) {
+	parts := strings.Split(latLng, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid coordinates %q", latLng)
+	}
+
+	latitude, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(parts[0]), "°"), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude in %q: %w", latLng, err)
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(parts[1]), "°"), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude in %q: %w", latLng, err)
+	}
+
+	return latitude, longitude, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadTimelineLocations(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "Timeline.json")
+	content := `{
+		"semanticSegments": [
+			{
+				"startTime": "2024-04-19T21:00:00.000+01:00",
+				"endTime": "2024-04-19T22:00:00.000+01:00",
+				"timelinePath": [
+					{"point": "39.5107349°, -9.1427899°", "time": "2024-04-19T21:08:00.000+01:00"}
+				]
+			},
+			{
+				"startTime": "2024-04-19T22:00:00.000+01:00",
+				"endTime": "2024-04-19T23:30:00.000+01:00",
+				"visit": {"topCandidate": {"placeLocation": {"latLng": "25.8135945°, 8.1338558°"}}}
+			}
+		],
+		"rawSignals": [
+			{"wifiScan": {"deliveryTime": "2024-04-19T21:00:00.000+01:00"}},
+			{"position": {"LatLng": "39.5°, -9.1°", "accuracyMeters": 10, "timestamp": "2024-04-19T21:30:00.000+01:00"}}
+		],
+		"userLocationProfile": {"frequentPlaces": []}
+	}`
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+
+	locations, err := readLocations(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to read locations: %v", err)
+	}
+
+	// 1 path point, the visit, 1 raw position
+	if locations.Len() != 3 {
+		t.Fatalf("Expected 3 locations, got %d", locations.Len())
+	}
+
+	first, _ := locations.Min()
+	expectedTime := time.Date(2024, 4, 19, 20, 8, 0, 0, time.UTC)
+	if !first.Timestamp.Equal(expectedTime) {
+		t.Errorf("Expected first timestamp %v, got %v", expectedTime, first.Timestamp)
+	}
+	if first.LatitudeE7 != 395107349 || first.LongitudeE7 != -91427899 {
+		t.Errorf("Expected 395107349, -91427899, got %d, %d", first.LatitudeE7, first.LongitudeE7)
+	}
+}
+
+func TestParseTimelineLatLng(t *testing.T) {
+	tests := []struct {
+		name        string
+		latLng      string
+		expectError bool
+		expectedLat float64
+		expectedLon float64
+	}{
+		{
+			name:        "Valid",
+			latLng:      "39.5107349°, -9.1427899°",
+			expectedLat: 39.5107349,
+			expectedLon: -9.1427899,
+		},
+		{
+			name:        "NoDegreeSign",
+			latLng:      "39.5,-9.1",
+			expectedLat: 39.5,
+			expectedLon: -9.1,
+		},
+		{
+			name:        "MissingLongitude",
+			latLng:      "39.5°",
+			expectError: true,
+		},
+		{
+			name:        "NotANumber",
+			latLng:      "north°, -9.1°",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, lon, err := parseTimelineLatLng(tt.latLng)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got %v, %v", lat, lon)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if lat != tt.expectedLat || lon != tt.expectedLon {
+				t.Errorf("Expected %v, %v, got %v, %v", tt.expectedLat, tt.expectedLon, lat, lon)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// embeds the IANA time zone database so that zones and their DST rules resolve the same on every system
+	_ "time/tzdata"
+
+	"github.com/google/btree"
+	"github.com/sirupsen/logrus"
+)
+
+// Cameras record DateTimeOriginal in local time. When the offset isn't recorded alongside it, the time zone can be
+// worked out from where the photo was taken, with the time zone boundaries from
+// https://github.com/evansiroky/timezone-boundary-builder (the combined.geojson or combined-with-oceans.geojson release).
+// Without them, the tool bundles the reference city of every zone of the IANA time zone database, to approximate the
+// zone of a place with the one of the closest city. That's only a rough guess: a zone's city can be far from parts of
+// it and close to a neighbouring zone, Vigo is closer to Lisbon than to Madrid, so it's only used when asked for.
+
+// the layout of the exif date time tags
+const exifDateTimeLayout = "2006:01:02 15:04:05"
+
+// the widest offsets from UTC of a local time, UTC-12 and UTC+14
+const (
+	minZoneOffset = -12 * time.Hour
+	maxZoneOffset = 14 * time.Hour
+)
+
+// places further than this from the reference location of every zone, in metres, are taken to be at sea, in the
+// nautical time zone of their longitude
+const maxTimezoneReferenceDistance = 1500000
+
+// the time between the locations looked up for candidate zones, the zone can't change much within it
+const candidateZoneInterval = 10 * time.Minute
+
+//go:embed timezones.tab
+var embeddedTimezones string
+
+type TimezoneBoundaries struct {
+	zones []timezoneZone
+	// the reference location of each zone, used when there are no zone polygons
+	references []timezoneReference
+}
+
+type timezoneReference struct {
+	name     string
+	location Location
+}
+
+type timezoneZone struct {
+	name string
+	// each polygon is a list of rings, the first one being the outer boundary and the others holes
+	polygons [][][][2]float64
+	// bounding box as min longitude, min latitude, max longitude, max latitude
+	bbox [4]float64
+}
+
+type timezoneFeatureCollection struct {
+	Features []struct {
+		Properties struct {
+			TZID string `json:"tzid"`
+		} `json:"properties"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// reads a time zone boundaries GeoJSON file where each feature is a Polygon or MultiPolygon with a tzid property
+func readTimezoneBoundaries(boundariesFile string) (*TimezoneBoundaries, error) {
+	bytes, err := os.ReadFile(boundariesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var collection timezoneFeatureCollection
+	if err := json.Unmarshal(bytes, &collection); err != nil {
+		return nil, err
+	}
+
+	boundaries := &TimezoneBoundaries{}
+	for _, feature := range collection.Features {
+		zone := timezoneZone{name: feature.Properties.TZID}
+		switch feature.Geometry.Type {
+		case "Polygon":
+			var polygon [][][2]float64
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &polygon); err != nil {
+				return nil, fmt.Errorf("%v: %w", zone.name, err)
+			}
+			zone.polygons = [][][][2]float64{polygon}
+		case "MultiPolygon":
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &zone.polygons); err != nil {
+				return nil, fmt.Errorf("%v: %w", zone.name, err)
+			}
+		default:
+			continue
+		}
+
+		zone.bbox = [4]float64{180, 90, -180, -90}
+		for _, polygon := range zone.polygons {
+			if len(polygon) == 0 {
+				continue
+			}
+			for _, point := range polygon[0] {
+				zone.bbox[0], zone.bbox[1] = min(zone.bbox[0], point[0]), min(zone.bbox[1], point[1])
+				zone.bbox[2], zone.bbox[3] = max(zone.bbox[2], point[0]), max(zone.bbox[3], point[1])
+			}
+		}
+		boundaries.zones = append(boundaries.zones, zone)
+	}
+
+	return boundaries, nil
+}
+
+// reads the reference locations of the time zones bundled with the tool
+func readEmbeddedTimezoneBoundaries() (*TimezoneBoundaries, error) {
+	boundaries := &TimezoneBoundaries{}
+	for i, line := range strings.Split(embeddedTimezones, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %v: expected coordinates and a time zone", i+1)
+		}
+		latitude, longitude, err := parseISO6709(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", i+1, err)
+		}
+		boundaries.references = append(boundaries.references, timezoneReference{name: fields[1], location: locationFromDegrees(latitude, longitude, time.Time{})})
+	}
+	return boundaries, nil
+}
+
+// parses ISO 6709 coordinates as written in the IANA zone.tab, +-DDMM+-DDDMM or +-DDMMSS+-DDDMMSS
+func parseISO6709(coordinates string) (float64, float64, error) {
+	if len(coordinates) < 2 {
+		return 0, 0, fmt.Errorf("invalid coordinates %v", coordinates)
+	}
+	split := strings.IndexAny(coordinates[1:], "+-") + 1
+	if split == 0 {
+		return 0, 0, fmt.Errorf("invalid coordinates %v", coordinates)
+	}
+	latitude, err := parseISO6709Degrees(coordinates[:split], 2)
+	if err != nil {
+		return 0, 0, err
+	}
+	longitude, err := parseISO6709Degrees(coordinates[split:], 3)
+	return latitude, longitude, err
+}
+
+// parses signed degrees with the given number of digits followed by minutes and optionally seconds
+func parseISO6709Degrees(value string, degreeDigits int) (float64, error) {
+	digits := value[1:]
+	if len(digits) != degreeDigits+2 && len(digits) != degreeDigits+4 {
+		return 0, fmt.Errorf("invalid coordinate %v", value)
+	}
+	parts := []string{digits[:degreeDigits], digits[degreeDigits : degreeDigits+2]}
+	if len(digits) == degreeDigits+4 {
+		parts = append(parts, digits[degreeDigits+2:])
+	}
+	degrees := 0.0
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid coordinate %v", value)
+		}
+		degrees += float64(number) / math.Pow(60, float64(i))
+	}
+	if value[0] == '-' {
+		degrees = -degrees
+	}
+	return degrees, nil
+}
+
+// returns the name of the time zone the coordinates are in, empty if they aren't in any
+func (b *TimezoneBoundaries) lookup(latitude, longitude float64) string {
+	for _, zone := range b.zones {
+		if longitude < zone.bbox[0] || latitude < zone.bbox[1] || longitude > zone.bbox[2] || latitude > zone.bbox[3] {
+			continue
+		}
+		for _, polygon := range zone.polygons {
+			if polygonContains(polygon, longitude, latitude) {
+				return zone.name
+			}
+		}
+	}
+	if len(b.references) > 0 {
+		return b.nearestTimezone(latitude, longitude)
+	}
+	return ""
+}
+
+// returns the zone whose reference location is the closest to the coordinates, or the nautical time zone of the
+// longitude when they're far from every reference location
+func (b *TimezoneBoundaries) nearestTimezone(latitude, longitude float64) string {
+	place := locationFromDegrees(latitude, longitude, time.Time{})
+	nearest, nearestDistance := "", math.Inf(1)
+	for _, reference := range b.references {
+		if d := distance(place, reference.location); d < nearestDistance {
+			nearest, nearestDistance = reference.name, d
+		}
+	}
+	if nearestDistance <= maxTimezoneReferenceDistance {
+		return nearest
+	}
+	// the sign of the Etc zones is inverted, Etc/GMT-2 is UTC+2
+	offset := int(math.Round(longitude / 15))
+	if offset == 0 {
+		return "Etc/GMT"
+	}
+	return fmt.Sprintf("Etc/GMT%+d", -offset)
+}
+
+func polygonContains(polygon [][][2]float64, x, y float64) bool {
+	if len(polygon) == 0 || !ringContains(polygon[0], x, y) {
+		return false
+	}
+	for _, hole := range polygon[1:] {
+		if ringContains(hole, x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+// ray casting point in polygon test
+func ringContains(ring [][2]float64, x, y float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi, xj, yj := ring[i][0], ring[i][1], ring[j][0], ring[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// parses an exif date time. With an offset, from OffsetTimeOriginal or OffsetTime, the result is exact.
+// Without one the date time is taken to be in the given zone and hasOffset is false.
+func parseCaptureTime(dateTime, offset string, zone *time.Location) (captureTime time.Time, hasOffset bool, err error) {
+	if offset != "" {
+		captureTime, err = time.Parse(exifDateTimeLayout+"-07:00", dateTime+offset)
+		return captureTime, err == nil, err
+	}
+	captureTime, err = time.ParseInLocation(exifDateTimeLayout, dateTime, zone)
+	return captureTime, false, err
+}
+
+// finds the location of a photo whose capture time has no known offset. The wall clock time is first read in the zone it
+// was parsed in, then in the zone of the location found for it, and so on until the zone doesn't change anymore.
+// When nothing matches in the zone it was parsed in, like a photo taken far away with the camera clock on local time,
+// the zones of the locations recorded around it are tried instead, see findLocationInCandidateZones.
+// Returns the location found and the capture time in the zone that was settled on.
+func findLocationFromLocalTime(locations *btree.BTreeG[Location], captureTime time.Time, boundaries *TimezoneBoundaries) (*Location, time.Time) {
+	location := findLocationFromDate(locations, captureTime)
+	if boundaries == nil {
+		return location, captureTime
+	}
+	if location == nil {
+		return findLocationInCandidateZones(locations, captureTime, boundaries)
+	}
+
+	// a few iterations are enough, more would only happen when bouncing between zones near a border
+	for i := 0; i < 3 && location != nil; i++ {
+		name := boundaries.lookup(location.latitude(), location.longitude())
+		if name == "" || name == captureTime.Location().String() {
+			break
+		}
+
+		zone, err := time.LoadLocation(name)
+		if err != nil {
+			logrus.Debugf("Unknown time zone %v: %v", name, err)
+			break
+		}
+
+		zonedTime := wallClockIn(captureTime, zone)
+		logrus.Tracef("Trying time zone %v for %v", name, zonedTime)
+
+		next := findLocationFromDate(locations, zonedTime)
+		if next == nil {
+			// keep the previous match rather than losing the photo because the zone shifted it outside the tolerance
+			break
+		}
+		location, captureTime = next, zonedTime
+	}
+
+	return location, captureTime
+}
+
+// finds the location of a wall clock time that matches nothing in the zone it was parsed in. Whatever zone the camera
+// clock was on, the photo was taken within the widest offsets from UTC of the wall clock time, so the zones of the
+// locations recorded in that window are the candidates. The time is read in each of them, and the zone is kept when
+// the location found for it is in that same zone. Returns the match closest in time, nil if no zone matches.
+func findLocationInCandidateZones(locations *btree.BTreeG[Location], captureTime time.Time, boundaries *TimezoneBoundaries) (*Location, time.Time) {
+	wallClock := wallClockIn(captureTime, time.UTC)
+	candidates := map[string]bool{}
+	var lastLookup time.Time
+	locations.AscendRange(Location{Timestamp: wallClock.Add(-maxZoneOffset - *tolerance)}, Location{Timestamp: wallClock.Add(-minZoneOffset + *tolerance)}, func(l Location) bool {
+		if !lastLookup.IsZero() && l.Timestamp.Sub(lastLookup) < candidateZoneInterval {
+			return true
+		}
+		lastLookup = l.Timestamp
+		if name := boundaries.lookup(l.latitude(), l.longitude()); name != "" {
+			candidates[name] = true
+		}
+		return true
+	})
+	names := []string{}
+	for name := range candidates {
+		names = append(names, name)
+	}
+	// the closest match wins, ties go to the first zone in alphabetical order for the result not to depend on map order
+	sort.Strings(names)
+
+	var best *Location
+	bestTime := captureTime
+	for _, name := range names {
+		if name == captureTime.Location().String() {
+			continue
+		}
+		zone, err := time.LoadLocation(name)
+		if err != nil {
+			logrus.Debugf("Unknown time zone %v: %v", name, err)
+			continue
+		}
+		zonedTime := wallClockIn(captureTime, zone)
+		location := findLocationFromDate(locations, zonedTime)
+		if location == nil || boundaries.lookup(location.latitude(), location.longitude()) != name {
+			continue
+		}
+		logrus.Tracef("Candidate time zone %v matches %v", name, zonedTime)
+		if best == nil || location.timeDifference(zonedTime) < best.timeDifference(bestTime) {
+			best, bestTime = location, zonedTime
+		}
+	}
+	return best, bestTime
+}
+
+// returns the same wall clock time in another zone
+func wallClockIn(t time.Time, zone *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), zone)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/btree"
+)
+
+// two made up square zones side by side, Lisbon with a hole in it that belongs to no zone
+const testTimezoneBoundaries = `{"type": "FeatureCollection", "features": [
+	{"type": "Feature", "properties": {"tzid": "Europe/Lisbon"}, "geometry": {"type": "Polygon", "coordinates": [
+		[[-10, 37], [-6, 37], [-6, 42], [-10, 42], [-10, 37]],
+		[[-8, 38], [-7, 38], [-7, 39], [-8, 39], [-8, 38]]
+	]}},
+	{"type": "Feature", "properties": {"tzid": "Europe/Madrid"}, "geometry": {"type": "MultiPolygon", "coordinates": [
+		[[[-6, 36], [3, 36], [3, 43], [-6, 43], [-6, 36]]]
+	]}}
+]}`
+
+func readTestTimezoneBoundaries(t *testing.T) *TimezoneBoundaries {
+	tmpFile := filepath.Join(t.TempDir(), "combined.geojson")
+	if err := os.WriteFile(tmpFile, []byte(testTimezoneBoundaries), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+
+	boundaries, err := readTimezoneBoundaries(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to read time zone boundaries: %v", err)
+	}
+	return boundaries
+}
+
+func TestTimezoneBoundariesLookup(t *testing.T) {
+	boundaries := readTestTimezoneBoundaries(t)
+
+	tests := []struct {
+		name      string
+		latitude  float64
+		longitude float64
+		expected  string
+	}{
+		{"Lisbon", 38.7223, -9.1393, "Europe/Lisbon"},
+		{"Madrid", 40.4168, -3.7038, "Europe/Madrid"},
+		{"InsideHole", 38.5, -7.5, ""},
+		{"Ocean", 38.7, -20, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := boundaries.lookup(tt.latitude, tt.longitude); result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseCaptureTime(t *testing.T) {
+	lisbon, err := time.LoadLocation("Europe/Lisbon")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		dateTime    string
+		offset      string
+		zone        *time.Location
+		expected    time.Time
+		expectError bool
+		hasOffset   bool
+	}{
+		{
+			name:      "WithOffset",
+			dateTime:  "2019:04:19 21:08:28",
+			offset:    "+01:00",
+			zone:      time.UTC,
+			expected:  time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+			hasOffset: true,
+		},
+		{
+			// Lisbon is on summer time in April
+			name:     "InZone",
+			dateTime: "2019:04:19 21:08:28",
+			zone:     lisbon,
+			expected: time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+		},
+		{
+			name:     "InZoneWinter",
+			dateTime: "2019:01:19 21:08:28",
+			zone:     lisbon,
+			expected: time.Date(2019, 1, 19, 21, 8, 28, 0, time.UTC),
+		},
+		{
+			name:        "InvalidOffset",
+			dateTime:    "2019:04:19 21:08:28",
+			offset:      "CET",
+			zone:        time.UTC,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, hasOffset, err := parseCaptureTime(tt.dateTime, tt.offset, tt.zone)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !result.Equal(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+			if hasOffset != tt.hasOffset {
+				t.Errorf("Expected hasOffset %v, got %v", tt.hasOffset, hasOffset)
+			}
+		})
+	}
+}
+
+func TestFindLocationFromLocalTime(t *testing.T) {
+	boundaries := readTestTimezoneBoundaries(t)
+
+	locations := btree.NewG[Location](2, locationLessFunc)
+	locations.ReplaceOrInsert(Location{LatitudeE7: 387223000, LongitudeE7: -91393000, Timestamp: time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)})
+	locations.ReplaceOrInsert(Location{LatitudeE7: 404168000, LongitudeE7: -37038000, Timestamp: time.Date(2019, 7, 1, 13, 40, 0, 0, time.UTC)})
+
+	// Save original tolerance
+	originalTolerance := tolerance
+	defer func() { tolerance = originalTolerance }()
+
+	testTolerance := 2 * time.Hour
+	tolerance = &testTolerance
+
+	// 13:00 on the camera clock. Read as UTC it's closest to Madrid, as Madrid time it's closest to Lisbon,
+	// and as Lisbon time it matches the Lisbon location exactly.
+	captureTime := time.Date(2019, 7, 1, 13, 0, 0, 0, time.UTC)
+
+	t.Run("WithoutBoundaries", func(t *testing.T) {
+		location, resolved := findLocationFromLocalTime(locations, captureTime, nil)
+		if location == nil || location.LatitudeE7 != 404168000 {
+			t.Errorf("Expected the Madrid location, got %+v", location)
+		}
+		if !resolved.Equal(captureTime) {
+			t.Errorf("Expected capture time %v, got %v", captureTime, resolved)
+		}
+	})
+
+	t.Run("WithBoundaries", func(t *testing.T) {
+		location, resolved := findLocationFromLocalTime(locations, captureTime, boundaries)
+		if location == nil || location.LatitudeE7 != 387223000 {
+			t.Errorf("Expected the Lisbon location, got %+v", location)
+		}
+		expected := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+		if !resolved.Equal(expected) || resolved.Location().String() != "Europe/Lisbon" {
+			t.Errorf("Expected capture time %v in Europe/Lisbon, got %v in %v", expected, resolved, resolved.Location())
+		}
+	})
+}
+
+func TestEmbeddedTimezoneBoundaries(t *testing.T) {
+	boundaries, err := readEmbeddedTimezoneBoundaries()
+	if err != nil {
+		t.Fatalf("Failed to read the bundled time zones: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		latitude  float64
+		longitude float64
+		expected  string
+	}{
+		{"Porto", 41.15, -8.61, "Europe/Lisbon"},
+		{"Valencia", 39.47, -0.38, "Europe/Madrid"},
+		{"Kyoto", 35.01, 135.77, "Asia/Tokyo"},
+		{"Chicago", 41.88, -87.63, "America/Chicago"},
+		{"MidAtlantic", 30, -40, "Etc/GMT+3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := boundaries.lookup(tt.latitude, tt.longitude)
+			if name != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, name)
+			}
+			if _, err := time.LoadLocation(name); err != nil {
+				t.Errorf("Expected a known time zone: %v", err)
+			}
+		})
+	}
+
+	for _, reference := range boundaries.references {
+		if _, err := time.LoadLocation(reference.name); err != nil {
+			t.Errorf("Unknown bundled time zone %v: %v", reference.name, err)
+		}
+	}
+}
+
+func TestFindLocationInCandidateZones(t *testing.T) {
+	boundaries, err := readEmbeddedTimezoneBoundaries()
+	if err != nil {
+		t.Fatalf("Failed to read the bundled time zones: %v", err)
+	}
+
+	originalTolerance := tolerance
+	defer func() { tolerance = originalTolerance }()
+	testTolerance := time.Hour
+	tolerance = &testTolerance
+
+	// in Tokyo at noon local time, 03:00 UTC, after a flight from Lisbon
+	locations := btree.NewG[Location](2, locationLessFunc)
+	locations.ReplaceOrInsert(Location{LatitudeE7: 387223000, LongitudeE7: -91393000, Timestamp: time.Date(2019, 6, 30, 8, 0, 0, 0, time.UTC)})
+	locations.ReplaceOrInsert(Location{LatitudeE7: 356762000, LongitudeE7: 1396503000, Timestamp: time.Date(2019, 7, 1, 3, 0, 0, 0, time.UTC)})
+
+	// the camera clock was set to Tokyo time but the photo is read in UTC, 9 hours off the Tokyo location
+	captureTime := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	if location := findLocationFromDate(locations, captureTime); location != nil {
+		t.Fatalf("Expected no match in UTC, got %+v", location)
+	}
+
+	location, resolved := findLocationFromLocalTime(locations, captureTime, boundaries)
+	if location == nil || location.LatitudeE7 != 356762000 {
+		t.Fatalf("Expected the Tokyo location, got %+v", location)
+	}
+	if expected := time.Date(2019, 7, 1, 3, 0, 0, 0, time.UTC); !resolved.Equal(expected) || resolved.Location().String() != "Asia/Tokyo" {
+		t.Errorf("Expected capture time %v in Asia/Tokyo, got %v in %v", expected, resolved, resolved.Location())
+	}
+
+	// no zone around puts the wall clock time close to a location in that zone
+	if location, _ := findLocationFromLocalTime(locations, time.Date(2019, 7, 1, 20, 0, 0, 0, time.UTC), boundaries); location != nil {
+		t.Errorf("Expected no location, got %+v", location)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tracks recorded by GPS devices and apps other than Google's: GPX, KML (gx:Track and timestamped placemarks) and GeoJSON.
+
+type GPXPoint struct {
+	Latitude  float64   `xml:"lat,attr"`
+	Longitude float64   `xml:"lon,attr"`
+	Elevation *float64  `xml:"ele"`
+	Time      time.Time `xml:"time"`
+}
+
+type KMLPlacemark struct {
+	TimeStamp struct {
+		When string `xml:"when"`
+	} `xml:"TimeStamp"`
+	Point struct {
+		Coordinates string `xml:"coordinates"`
+	} `xml:"Point"`
+	Tracks      []KMLTrack `xml:"Track"`
+	MultiTracks []KMLTrack `xml:"MultiTrack>Track"`
+}
+
+// a gx:Track, with the when and gx:coord elements paired by their order
+type KMLTrack struct {
+	When  []string `xml:"when"`
+	Coord []string `xml:"coord"`
+}
+
+type GeoJSONFeature struct {
+	Geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties struct {
+		CoordTimes json.RawMessage `json:"coordTimes"`
+		Times      json.RawMessage `json:"times"`
+		Time       string          `json:"time"`
+		Timestamp  string          `json:"timestamp"`
+	} `json:"properties"`
+}
+
+// decodes GPX and KML files as a stream, the element names of both formats don't overlap so a single pass handles either
+func decodeXMLLocations(reader io.Reader, insert func(Location)) error {
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "trkpt", "rtept", "wpt":
+			var point GPXPoint
+			if err := decoder.DecodeElement(&point, &start); err != nil {
+				return err
+			}
+			if !point.Time.IsZero() {
+				location := locationFromDegrees(point.Latitude, point.Longitude, point.Time)
+				location.Altitude = point.Elevation
+				insert(location)
+			}
+		case "Placemark":
+			var placemark KMLPlacemark
+			if err := decoder.DecodeElement(&placemark, &start); err != nil {
+				return err
+			}
+			if err := insertKMLPlacemark(placemark, insert); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func insertKMLPlacemark(placemark KMLPlacemark, insert func(Location)) error {
+	if placemark.TimeStamp.When != "" && placemark.Point.Coordinates != "" {
+		timestamp, err := time.Parse(time.RFC3339, strings.TrimSpace(placemark.TimeStamp.When))
+		if err != nil {
+			return err
+		}
+		location, err := parseCoordinates(strings.Split(strings.TrimSpace(placemark.Point.Coordinates), ","), timestamp)
+		if err != nil {
+			return err
+		}
+		insert(location)
+	}
+
+	for _, track := range append(placemark.Tracks, placemark.MultiTracks...) {
+		if len(track.When) != len(track.Coord) {
+			return fmt.Errorf("gx:Track has %v when elements but %v gx:coord elements", len(track.When), len(track.Coord))
+		}
+		for i := range track.When {
+			timestamp, err := time.Parse(time.RFC3339, strings.TrimSpace(track.When[i]))
+			if err != nil {
+				return err
+			}
+			location, err := parseCoordinates(strings.Fields(track.Coord[i]), timestamp)
+			if err != nil {
+				return err
+			}
+			insert(location)
+		}
+	}
+
+	return nil
+}
+
+// decodes an entry of the features array of a GeoJSON feature collection.
+// Points need a time or timestamp property, LineStrings and MultiLineStrings a coordTimes or times property
+// with one timestamp per coordinate, like the ones written by togeojson and most tracking apps.
+func decodeGeoJSONFeature(decoder *json.Decoder, insert func(Location)) error {
+	var feature GeoJSONFeature
+	if err := decoder.Decode(&feature); err != nil {
+		return err
+	}
+
+	times := feature.Properties.CoordTimes
+	if len(times) == 0 {
+		times = feature.Properties.Times
+	}
+
+	switch feature.Geometry.Type {
+	case "Point":
+		when := feature.Properties.Time
+		if when == "" {
+			when = feature.Properties.Timestamp
+		}
+		if when == "" {
+			return nil
+		}
+		var coordinates []float64
+		if err := json.Unmarshal(feature.Geometry.Coordinates, &coordinates); err != nil {
+			return err
+		}
+		return insertGeoJSONLine([][]float64{coordinates}, []string{when}, insert)
+	case "LineString":
+		if len(times) == 0 {
+			return nil
+		}
+		var coordinates [][]float64
+		var lineTimes []string
+		if err := json.Unmarshal(feature.Geometry.Coordinates, &coordinates); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(times, &lineTimes); err != nil {
+			return err
+		}
+		return insertGeoJSONLine(coordinates, lineTimes, insert)
+	case "MultiLineString":
+		if len(times) == 0 {
+			return nil
+		}
+		var coordinates [][][]float64
+		var lineTimes [][]string
+		if err := json.Unmarshal(feature.Geometry.Coordinates, &coordinates); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(times, &lineTimes); err != nil {
+			return err
+		}
+		if len(coordinates) != len(lineTimes) {
+			return fmt.Errorf("MultiLineString has %v lines but %v lists of times", len(coordinates), len(lineTimes))
+		}
+		for i := range coordinates {
+			if err := insertGeoJSONLine(coordinates[i], lineTimes[i], insert); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func insertGeoJSONLine(coordinates [][]float64, times []string, insert func(Location)) error {
+	if len(coordinates) != len(times) {
+		return fmt.Errorf("line has %v coordinates but %v times", len(coordinates), len(times))
+	}
+	for i, position := range coordinates {
+		if len(position) < 2 {
+			return fmt.Errorf("invalid position %v", position)
+		}
+		timestamp, err := time.Parse(time.RFC3339, times[i])
+		if err != nil {
+			return err
+		}
+		insert(locationFromPosition(position, timestamp))
+	}
+	return nil
+}
+
+// parses KML coordinates, which are longitude first with an optional altitude
+func parseCoordinates(values []string, timestamp time.Time) (Location, error) {
+	if len(values) < 2 {
+		return Location{}, fmt.Errorf("invalid coordinates %v", values)
+	}
+	position := make([]float64, len(values))
+	for i, v := range values {
+		value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return Location{}, err
+		}
+		position[i] = value
+	}
+	return locationFromPosition(position, timestamp), nil
+}
+
+// turns a longitude, latitude and optional altitude, the order used by both KML and GeoJSON, into a location
+func locationFromPosition(position []float64, timestamp time.Time) Location {
+	location := locationFromDegrees(position[1], position[0], timestamp)
+	if len(position) > 2 {
+		altitude := position[2]
+		location.Altitude = &altitude
+	}
+	return location
+}
+
+func locationFromDegrees(latitude, longitude float64, timestamp time.Time) Location {
+	return Location{
+		LatitudeE7:  int(math.Round(latitude * 1e7)),
+		LongitudeE7: int(math.Round(longitude * 1e7)),
+		Timestamp:   timestamp.UTC(),
+	}
+}
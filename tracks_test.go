@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadTrackLocations(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+		expected []Location
+	}{
+		{
+			name:     "GPX",
+			fileName: "track.gpx",
+			content: `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="Garmin" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="10.0" lon="20.0"><name>No time</name></wpt>
+  <trk><trkseg>
+    <trkpt lat="39.5107349" lon="-9.1427899"><ele>12.3</ele><time>2019-04-19T20:08:28Z</time></trkpt>
+    <trkpt lat="25.8135945" lon="8.1338558"><time>2019-04-19T21:18:28+01:00</time></trkpt>
+  </trkseg></trk>
+</gpx>`,
+			expected: []Location{
+				{LatitudeE7: 395107349, LongitudeE7: -91427899, Timestamp: time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC)},
+				{LatitudeE7: 258135945, LongitudeE7: 81338558, Timestamp: time.Date(2019, 4, 19, 20, 18, 28, 0, time.UTC)},
+			},
+		},
+		{
+			name:     "KML",
+			fileName: "track.kml",
+			content: `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">
+  <Document>
+    <Placemark>
+      <TimeStamp><when>2019-04-19T19:00:00Z</when></TimeStamp>
+      <Point><coordinates>8.1338558,25.8135945,0</coordinates></Point>
+    </Placemark>
+    <Placemark>
+      <gx:Track>
+        <when>2019-04-19T20:00:00Z</when>
+        <when>2019-04-19T20:01:00Z</when>
+        <gx:coord>-9.1427899 39.5107349 156</gx:coord>
+        <gx:coord>-9.1427800 39.5107300 157</gx:coord>
+      </gx:Track>
+    </Placemark>
+  </Document>
+</kml>`,
+			expected: []Location{
+				{LatitudeE7: 258135945, LongitudeE7: 81338558, Timestamp: time.Date(2019, 4, 19, 19, 0, 0, 0, time.UTC)},
+				{LatitudeE7: 395107349, LongitudeE7: -91427899, Timestamp: time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC)},
+				{LatitudeE7: 395107300, LongitudeE7: -91427800, Timestamp: time.Date(2019, 4, 19, 20, 1, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:     "GeoJSON",
+			fileName: "track.geojson",
+			content: `{"type": "FeatureCollection", "features": [
+				{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[-9.1427899, 39.5107349, 10], [8.1338558, 25.8135945]]},
+				 "properties": {"coordTimes": ["2019-04-19T20:00:00Z", "2019-04-19T21:00:00Z"]}},
+				{"type": "Feature", "geometry": {"type": "Point", "coordinates": [8.1338558, 25.8135945]},
+				 "properties": {"time": "2019-04-19T22:00:00Z"}},
+				{"type": "Feature", "geometry": {"type": "Point", "coordinates": [1, 1]}, "properties": {}}
+			]}`,
+			expected: []Location{
+				{LatitudeE7: 395107349, LongitudeE7: -91427899, Timestamp: time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC)},
+				{LatitudeE7: 258135945, LongitudeE7: 81338558, Timestamp: time.Date(2019, 4, 19, 21, 0, 0, 0, time.UTC)},
+				{LatitudeE7: 258135945, LongitudeE7: 81338558, Timestamp: time.Date(2019, 4, 19, 22, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			// no known extension, the format is sniffed from the contents
+			name:     "SniffedGPX",
+			fileName: "export.dat",
+			content:  "\n  <gpx><trk><trkseg><trkpt lat=\"1.5\" lon=\"-2.5\"><time>2019-04-19T20:00:00Z</time></trkpt></trkseg></trk></gpx>",
+			expected: []Location{
+				{LatitudeE7: 15000000, LongitudeE7: -25000000, Timestamp: time.Date(2019, 4, 19, 20, 0, 0, 0, time.UTC)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile := filepath.Join(t.TempDir(), tt.fileName)
+			if err := os.WriteFile(tmpFile, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to create temp file: %v", err)
+			}
+
+			locations, err := readLocations(tmpFile)
+			if err != nil {
+				t.Fatalf("Failed to read locations: %v", err)
+			}
+
+			if locations.Len() != len(tt.expected) {
+				t.Fatalf("Expected %d locations, got %d", len(tt.expected), locations.Len())
+			}
+			i := 0
+			locations.Ascend(func(l Location) bool {
+				expected := tt.expected[i]
+				if l.LatitudeE7 != expected.LatitudeE7 || l.LongitudeE7 != expected.LongitudeE7 || !l.Timestamp.Equal(expected.Timestamp) {
+					t.Errorf("Expected location %d to be %+v, got %+v", i, expected, l)
+				}
+				i++
+				return true
+			})
+		})
+	}
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		tmpFile := filepath.Join(t.TempDir(), "export.txt")
+		if err := os.WriteFile(tmpFile, []byte("lat,lon,time"), 0644); err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+
+		if _, err := readLocations(tmpFile); err == nil {
+			t.Error("Expected error reading an unknown format, got nil")
+		}
+	})
+}
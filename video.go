@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+// Videos keep their capture time in the QuickTime CreateDate, which is UTC unlike the exif date times,
+// and their location in the GPSCoordinates of the Keys (read by Apple and Google Photos) and UserData atoms.
+
+// sets the fields that write the location to the QuickTime atoms of a video. The coordinates are written as
+// they're stored, in ISO 6709, bypassing exiftool's conversion.
+func setVideoGPSFields(fields map[string]interface{}, location Location) {
+	coordinates := iso6709(location)
+	fields["Keys:GPSCoordinates#"] = coordinates
+	fields["UserData:GPSCoordinates#"] = coordinates
+}
+
+// formats the location as an ISO 6709 string, e.g. +39.510735-009.142790+012.500/
+func iso6709(location Location) string {
+	coordinates := fmt.Sprintf("%+010.6f%+011.6f", location.latitude(), location.longitude())
+	if location.Altitude != nil {
+		coordinates += fmt.Sprintf("%+.3f", *location.Altitude)
+	}
+	return coordinates + "/"
+}
+
+// reads the duration of a video, as printed by exiftool: 12.34 s for short clips and 0:01:23 for the others
+func videoDuration(fileinfo exiftool.FileMetadata) (time.Duration, bool) {
+	value, err := fileinfo.GetString("Duration")
+	if err != nil || value == "" {
+		return 0, false
+	}
+	value = strings.TrimSpace(strings.TrimSuffix(value, "(approx)"))
+
+	if seconds, ok := strings.CutSuffix(value, " s"); ok {
+		parsed, err := strconv.ParseFloat(seconds, 64)
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(parsed * float64(time.Second)), true
+	}
+
+	var duration time.Duration
+	for _, part := range strings.Split(value, ":") {
+		parsed, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, false
+		}
+		duration = duration*60 + time.Duration(parsed*float64(time.Second))
+	}
+	return duration, true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+func TestISO6709(t *testing.T) {
+	altitude := 12.5
+
+	tests := []struct {
+		name     string
+		location Location
+		expected string
+	}{
+		{"NorthWest", Location{LatitudeE7: 395107349, LongitudeE7: -91427899}, "+39.510735-009.142790/"},
+		{"SouthEast", Location{LatitudeE7: -338688000, LongitudeE7: 1512093000}, "-33.868800+151.209300/"},
+		{"WithAltitude", Location{LatitudeE7: 395107349, LongitudeE7: -91427899, Altitude: &altitude}, "+39.510735-009.142790+12.500/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := iso6709(tt.location); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	fields := map[string]interface{}{}
+	mediaHandlerFor("VID_0001.mp4").SetGPSFields(fields, tests[0].location)
+	for _, field := range []string{"Keys:GPSCoordinates#", "UserData:GPSCoordinates#"} {
+		if fields[field] != tests[0].expected {
+			t.Errorf("Expected %v to be %v, got %v", field, tests[0].expected, fields[field])
+		}
+	}
+}
+
+func TestVideoDuration(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected time.Duration
+		ok       bool
+	}{
+		{"12.34 s", 12340 * time.Millisecond, true},
+		{"0:01:23", 83 * time.Second, true},
+		{"1:00:00", time.Hour, true},
+		{"12.34 s (approx)", 12340 * time.Millisecond, true},
+		{"unknown", 0, false},
+		{nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		fields := map[string]interface{}{}
+		if tt.value != nil {
+			fields["Duration"] = tt.value
+		}
+		duration, ok := videoDuration(exiftool.FileMetadata{Fields: fields})
+		if duration != tt.expected || ok != tt.ok {
+			t.Errorf("Expected %v, %v for %v, got %v, %v", tt.expected, tt.ok, tt.value, duration, ok)
+		}
+	}
+}
+
+func TestReadVideoCaptureTime(t *testing.T) {
+	lisbon, err := time.LoadLocation("Europe/Lisbon")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		expected time.Time
+	}{
+		{
+			// the QuickTime CreateDate is UTC whatever the --timezone
+			name:     "CreateDate",
+			fields:   map[string]interface{}{"CreateDate": "2019:04:19 20:08:28"},
+			expected: time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+		},
+		{
+			name:     "CreationDate",
+			fields:   map[string]interface{}{"CreationDate": "2019:04:19 21:08:28+01:00", "CreateDate": "2019:04:19 20:08:29"},
+			expected: time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, hasOffset, _, err := readCaptureTime(exiftool.FileMetadata{File: "VID_0001.mp4", Fields: tt.fields}, []string{"DateTimeOriginal"}, lisbon)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !result.Equal(tt.expected) || !hasOffset {
+				t.Errorf("Expected %v with an offset, got %v, %v", tt.expected, result, hasOffset)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	exiftool "github.com/barasher/go-exiftool"
+	"github.com/sirupsen/logrus"
+)
+
+// Raw files are never modified, their location is written to an XMP sidecar next to them instead, as are
+// the files of every format with --output=sidecar.
+// Lightroom names the sidecar after the raw without its extension, IMG_0001.xmp, while darktable and digiKam
+// keep the extension, IMG_0001.CR2.xmp. An existing sidecar is used whatever its name, and merged into.
+// The sidecars of the other formats always keep the extension, for the JPEG of a RAW+JPEG pair not to share the
+// sidecar of its raw.
+
+// an empty XMP packet for exiftool to write the tags of a new sidecar into
+const emptyXMPSidecar = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+
+// returns the path of the XMP sidecar of the file, the existing one if there is one
+func xmpSidecarPath(filePath string) string {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	candidates := []string{base + ".xmp", filePath + ".xmp"}
+	if handler := mediaHandlerFor(filePath); handler == nil || !handler.XMPSidecar {
+		candidates = []string{filePath + ".xmp"}
+	} else if *xmpSidecarName == "full" {
+		candidates = []string{filePath + ".xmp", base + ".xmp"}
+	}
+
+	for _, candidate := range candidates {
+		for _, path := range []string{candidate, strings.TrimSuffix(candidate, ".xmp") + ".XMP"} {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				return path
+			}
+		}
+	}
+	return candidates[0]
+}
+
+// creates the XMP sidecars about to be written that don't exist yet, exiftool only writes to existing files
+func createMissingXMPSidecars(files []exiftool.FileMetadata) error {
+	for _, file := range files {
+		if strings.ToLower(filepath.Ext(file.File)) != ".xmp" {
+			continue
+		}
+		if _, err := os.Stat(file.File); !os.IsNotExist(err) {
+			continue
+		}
+		if err := os.WriteFile(file.File, []byte(emptyXMPSidecar), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the metadata of the existing XMP sidecars of the files that already have a location, for the files to be
+// skipped like the ones with a location in their own metadata
+func readSidecarsWithGPS(et *exiftool.Exiftool, files []string) map[string]exiftool.FileMetadata {
+	sidecars := []string{}
+	for _, file := range files {
+		if handler := mediaHandlerFor(file); handler == nil || !handler.usesXMPSidecar() {
+			continue
+		}
+		sidecar := xmpSidecarPath(file)
+		if _, err := os.Stat(sidecar); err == nil {
+			sidecars = append(sidecars, sidecar)
+		}
+	}
+
+	withGPS := map[string]exiftool.FileMetadata{}
+	if len(sidecars) == 0 {
+		return withGPS
+	}
+	for _, fileinfo := range et.ExtractMetadata(sidecars...) {
+		if fileinfo.Err != nil {
+			logrus.Warnf("Ignoring the XMP sidecar %v because of an error when extracting metadata: %v", fileinfo.File, fileinfo.Err)
+			continue
+		}
+		if fileinfo.Fields["GPSLatitude"] != nil || fileinfo.Fields["GPSLongitude"] != nil {
+			withGPS[fileinfo.File] = fileinfo
+		}
+	}
+	return withGPS
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	exiftool "github.com/barasher/go-exiftool"
+)
+
+func TestXMPSidecarPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		naming   string
+		existing string
+		expected string
+	}{
+		{"NewBase", "base", "", "IMG_0001.xmp"},
+		{"NewFull", "full", "", "IMG_0001.CR2.xmp"},
+		{"ExistingFull", "base", "IMG_0001.CR2.xmp", "IMG_0001.CR2.xmp"},
+		{"ExistingBase", "full", "IMG_0001.xmp", "IMG_0001.xmp"},
+		{"ExistingUppercase", "base", "IMG_0001.XMP", "IMG_0001.XMP"},
+	}
+
+	originalXMPSidecarName := xmpSidecarName
+	defer func() { xmpSidecarName = originalXMPSidecarName }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			naming := tt.naming
+			xmpSidecarName = &naming
+
+			tmpDir := t.TempDir()
+			if tt.existing != "" {
+				if err := os.WriteFile(filepath.Join(tmpDir, tt.existing), []byte(emptyXMPSidecar), 0644); err != nil {
+					t.Fatalf("Failed to create temp file: %v", err)
+				}
+			}
+
+			result := xmpSidecarPath(filepath.Join(tmpDir, "IMG_0001.CR2"))
+			if expected := filepath.Join(tmpDir, tt.expected); result != expected {
+				t.Errorf("Expected %v, got %v", expected, result)
+			}
+		})
+	}
+}
+
+func TestXMPSidecarPathRawJPEGPair(t *testing.T) {
+	originalXMPSidecarName := xmpSidecarName
+	defer func() { xmpSidecarName = originalXMPSidecarName }()
+	naming := "base"
+	xmpSidecarName = &naming
+
+	tmpDir := t.TempDir()
+	// the sidecar Lightroom made for the raw
+	if err := os.WriteFile(filepath.Join(tmpDir, "IMG_0001.xmp"), []byte(emptyXMPSidecar), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+
+	raw, jpeg := xmpSidecarPath(filepath.Join(tmpDir, "IMG_0001.CR2")), xmpSidecarPath(filepath.Join(tmpDir, "IMG_0001.JPG"))
+	if raw != filepath.Join(tmpDir, "IMG_0001.xmp") {
+		t.Errorf("Expected the raw to use its existing sidecar, got %v", raw)
+	}
+	if jpeg != filepath.Join(tmpDir, "IMG_0001.JPG.xmp") {
+		t.Errorf("Expected the JPEG to get a sidecar of its own, got %v", jpeg)
+	}
+}
+
+func TestPrepareWriteRaw(t *testing.T) {
+	tmpDir := t.TempDir()
+	rawPath := filepath.Join(tmpDir, "IMG_0001.CR2")
+	existingSidecar := filepath.Join(tmpDir, "IMG_0002.xmp")
+	if err := os.WriteFile(existingSidecar, []byte("existing"), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+
+	fileinfo := exiftool.FileMetadata{File: rawPath, Fields: map[string]interface{}{"Make": "Canon", "DateTimeOriginal": "2019:04:19 20:08:28"}}
+	location := Location{LatitudeE7: 395107349, LongitudeE7: -91427899, Timestamp: time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC)}
+
+	result := mediaHandlerFor(rawPath).prepareWrite(fileinfo, location)
+	if expected := filepath.Join(tmpDir, "IMG_0001.xmp"); result.File != expected {
+		t.Errorf("Expected the location to be written to %v, got %v", expected, result.File)
+	}
+	if result.Fields["XMP:GPSLatitude"] != float32(39.5107349) {
+		t.Errorf("Expected the XMP GPS latitude to be set, got %v", result.Fields)
+	}
+	if _, ok := result.Fields["Make"]; ok {
+		t.Errorf("Expected only the GPS tags to be written to the sidecar, got %v", result.Fields)
+	}
+	if _, ok := fileinfo.Fields["GPSLatitude"]; ok {
+		t.Errorf("Expected the raw fields to be left untouched, got %v", fileinfo.Fields)
+	}
+
+	files := []exiftool.FileMetadata{result, {File: existingSidecar}, {File: filepath.Join(tmpDir, "photo.jpg")}}
+	if err := createMissingXMPSidecars(files); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if content, err := os.ReadFile(result.File); err != nil || string(content) != emptyXMPSidecar {
+		t.Errorf("Expected an empty sidecar to be created, got %q: %v", content, err)
+	}
+	if content, _ := os.ReadFile(existingSidecar); string(content) != "existing" {
+		t.Errorf("Expected the existing sidecar to be left as is, got %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "photo.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be created for the jpg")
+	}
+}
+
+func TestPrepareWriteSidecarOutput(t *testing.T) {
+	originalOutput := output
+	defer func() { output = originalOutput }()
+
+	location := Location{LatitudeE7: 395107349, LongitudeE7: -91427899, Timestamp: time.Date(2019, 4, 19, 20, 8, 28, 0, time.UTC)}
+	tmpDir := t.TempDir()
+	photoPath := filepath.Join(tmpDir, "IMG_0001.jpg")
+
+	tests := []struct {
+		output   string
+		expected string
+		field    string
+	}{
+		{"file", photoPath, "GPSLatitude"},
+		{"sidecar", filepath.Join(tmpDir, "IMG_0001.jpg.xmp"), "XMP:GPSLatitude"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			mode := tt.output
+			output = &mode
+
+			fileinfo := exiftool.FileMetadata{File: photoPath, Fields: map[string]interface{}{"Make": "Canon"}}
+			result := mediaHandlerFor(photoPath).prepareWrite(fileinfo, location)
+			if result.File != tt.expected {
+				t.Errorf("Expected the location to be written to %v, got %v", tt.expected, result.File)
+			}
+			if result.Fields[tt.field] != float32(39.5107349) {
+				t.Errorf("Expected %v to be set, got %v", tt.field, result.Fields)
+			}
+		})
+	}
+}